@@ -0,0 +1,310 @@
+package templater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// cacheVersion is the version of the entries written to the cache directory.
+// It changes whenever what is inferred from a file changes, so that entries from older versions are inferred again.
+const cacheVersion = 3
+
+// A tableCache holds what was inferred from each file, so that a later run only infers the tables whose files changed.
+// Files are identified by a key, and a file whose key differs from the one it was cached under is inferred again.
+// Given a directory, entries are also kept there, one per file, so that they outlast the run.
+// Where PII is detected, the values of fields that look like PII are left out of the entries.
+// It is safe to use from the workers inferring each table at once.
+type tableCache struct {
+	key      func(fsys fs.FS, path string) (string, error)
+	dir      string
+	pii      bool
+	mu       sync.Mutex
+	entries  map[string]cacheEntry
+	inferred []string
+}
+
+// A cacheEntry is what was inferred from a file, before any of its fields were renamed.
+// Rather than every observation of the values of each field, it keeps only their [statsSummary].
+//
+// Key: The key identifying the contents of the file, and the [Options] it was inferred with.
+type cacheEntry struct {
+	Version     int                    `json:"version"`
+	File        string                 `json:"file"`
+	Key         string                 `json:"key"`
+	Fields      map[string]cachedField `json:"fields"`
+	Rows        int                    `json:"rows"`
+	TotalRows   int                    `json:"total_rows"`
+	Columns     map[string]int         `json:"columns"`
+	Seen        int                    `json:"seen"`
+	Format      csvFormat              `json:"format"`
+	Diagnostics []Diagnostic           `json:"diagnostics"`
+}
+
+// A cachedField is a [Field] in a [cacheEntry].
+type cachedField struct {
+	Node         string        `json:"node"`
+	Path         string        `json:"path"`
+	InferredType string        `json:"inferred_type"`
+	Stats        *statsSummary `json:"stats"`
+	Ordinal      int           `json:"ordinal"`
+	Seen         int           `json:"seen"`
+}
+
+// newTableCache returns an empty [tableCache], identifying files by the given key.
+// Entries are only kept in memory if the directory is empty. The values of fields that look like PII are only left out if pii is set.
+func newTableCache(key func(fsys fs.FS, path string) (string, error), dir string, pii bool) *tableCache {
+	return &tableCache{key: key, dir: dir, pii: pii, entries: make(map[string]cacheEntry)}
+}
+
+// tableCache returns the [tableCache] kept in the CacheDir of the [Options], or nil if there is no CacheDir.
+func (o Options) tableCache() *tableCache {
+	if o.CacheDir == "" {
+		return nil
+	}
+	return newTableCache(contentKey(o), o.CacheDir, o.detectsPII())
+}
+
+// contentKey identifies a file by a hash of its contents, along with the [Options] that change what is inferred from it:
+// the paths unpacked from JSON, the [Sampling] of rows, the [NamingConvention] of the fields,
+// and whether PII is detected, as that decides whether the values of fields that look like PII are kept.
+func contentKey(opts Options) func(fsys fs.FS, path string) (string, error) {
+	config, _ := json.Marshal(struct {
+		Version     int
+		UnpackPaths []string
+		Sampling    Sampling
+		Naming      NamingConvention
+		PII         bool
+	}{cacheVersion, opts.UnpackPaths, opts.Sampling, opts.Naming, opts.detectsPII()})
+	return func(fsys fs.FS, path string) (string, error) {
+		f, err := fsys.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		h := sha256.New()
+		h.Write(config)
+		_, err = io.Copy(h, f)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+// infer restores the fields of the [Table] from the cache if its file is unchanged, or otherwise infers and caches them.
+// Either way, the [Diagnostic]s of the table are added to the [Diagnostics].
+func (c *tableCache) infer(ctx context.Context, fsys fs.FS, table *Table, diagnostics *Diagnostics, unpackPaths ...string) error {
+	key, err := c.key(fsys, table.File)
+	if err != nil {
+		return &TableError{File: table.File, Err: err}
+	}
+	entry, ok := c.lookup(table.File, key)
+	if !ok {
+		table.diagnostics = &Diagnostics{}
+		err = generateTableFields(ctx, table, unpackPaths...)
+		if err != nil {
+			return err
+		}
+		entry = newCacheEntry(table, key, c.pii)
+		err = c.store(entry)
+		if err != nil {
+			return &TableError{File: table.File, Err: err}
+		}
+	}
+	entry.restore(table)
+	table.diagnostics = diagnostics
+	diagnostics.merge(entry.Diagnostics)
+	return nil
+}
+
+// lookup returns the entry of the file if it was cached under the key, either in memory or in the cache directory.
+// An entry that can't be read from the cache directory is treated as missing, and is replaced once the file is inferred again.
+func (c *tableCache) lookup(file string, key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[file]
+	c.mu.Unlock()
+	if ok && entry.Key == key {
+		return entry, true
+	}
+	if c.dir == "" {
+		return cacheEntry{}, false
+	}
+	contents, err := os.ReadFile(c.path(file))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	entry = cacheEntry{}
+	err = json.Unmarshal(contents, &entry)
+	if err != nil || entry.Version != cacheVersion || entry.File != file || entry.Key != key {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	c.entries[file] = entry
+	c.mu.Unlock()
+	return entry, true
+}
+
+// store caches the entry in memory and, if there is one, in the cache directory, recording that its file was inferred.
+func (c *tableCache) store(entry cacheEntry) error {
+	c.mu.Lock()
+	c.entries[entry.File] = entry
+	c.inferred = append(c.inferred, entry.File)
+	c.mu.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(contents)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), c.path(entry.File))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// cacheEntryName matches the names of the entries in the cache directory.
+var cacheEntryName = regexp.MustCompile(`^[0-9a-f]{64}\.json$`)
+
+// path returns where the entry of the file is kept in the cache directory, named after a hash of the file's path.
+func (c *tableCache) path(file string) string {
+	h := sha256.Sum256([]byte(file))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+".json")
+}
+
+// retain drops the files of any tables no longer in the project from the cache, and from the cache directory.
+// A nil *tableCache does nothing.
+func (c *tableCache) retain(tables []*Table) error {
+	if c == nil {
+		return nil
+	}
+	files := make(map[string]bool)
+	entries := make(map[string]bool)
+	for _, table := range tables {
+		files[table.File] = true
+		entries[filepath.Base(c.path(table.File))] = true
+	}
+	c.mu.Lock()
+	for file := range c.entries {
+		if !files[file] {
+			delete(c.entries, file)
+		}
+	}
+	c.mu.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+	cached, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range cached {
+		if cacheEntryName.MatchString(entry.Name()) && !entries[entry.Name()] {
+			err = os.Remove(filepath.Join(c.dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// takeInferred returns the files inferred since it was last called, in sorted order.
+func (c *tableCache) takeInferred() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	inferred := c.inferred
+	c.inferred = nil
+	sort.Strings(inferred)
+	return inferred
+}
+
+// newCacheEntry records what was inferred from the file of the [Table], under the key.
+// Given pii, the values of fields that look like PII are left out of their summaries.
+func newCacheEntry(table *Table, key string, pii bool) cacheEntry {
+	entry := cacheEntry{
+		Version:     cacheVersion,
+		File:        table.File,
+		Key:         key,
+		Fields:      make(map[string]cachedField),
+		Rows:        table.Rows,
+		TotalRows:   table.TotalRows,
+		Columns:     make(map[string]int),
+		Seen:        table.seen,
+		Format:      table.format,
+		Diagnostics: table.diagnostics.List(),
+	}
+	for path, field := range table.Fields {
+		var stats *statsSummary
+		if field.Stats != nil {
+			stats = field.Stats.summarise(pii && classifyPII(field) != "")
+		}
+		entry.Fields[path] = cachedField{
+			Node:         field.Node,
+			Path:         field.Path,
+			InferredType: field.InferredType,
+			Stats:        stats,
+			Ordinal:      field.Ordinal,
+			Seen:         field.seen,
+		}
+	}
+	for column, ordinal := range table.columns {
+		entry.Columns[column] = ordinal
+	}
+	return entry
+}
+
+// restore sets the fields of the [Table], and what else was inferred along with them, from the entry.
+func (e cacheEntry) restore(table *Table) {
+	table.Fields = make(map[string]Field)
+	for path, field := range e.Fields {
+		var stats *FieldStats
+		if field.Stats != nil {
+			stats = field.Stats.restore()
+		}
+		table.Fields[path] = Field{
+			Node:         field.Node,
+			Path:         field.Path,
+			InferredType: field.InferredType,
+			Stats:        stats,
+			Ordinal:      field.Ordinal,
+			seen:         field.Seen,
+		}
+	}
+	table.Rows = e.Rows
+	table.TotalRows = e.TotalRows
+	table.columns = make(map[string]int)
+	for column, ordinal := range e.Columns {
+		table.columns[column] = ordinal
+	}
+	table.seen = e.Seen
+	table.format = e.Format
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/mr-joshcrane/templater"
 )
 
 func main() {
-	templater.Main()
+	os.Exit(templater.Main())
 }
@@ -0,0 +1,154 @@
+package templater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// A CollisionStrategy determines how fields whose names collide after naming by a [NamingConvention] are renamed.
+type CollisionStrategy string
+
+const (
+	// CollisionSuffix renames colliding fields by appending _2, _3 and so on.
+	CollisionSuffix CollisionStrategy = "suffix"
+	// CollisionQualify renames colliding fields unpacked from JSON by qualifying them with the column they were unpacked from,
+	// falling back to a suffix where that still collides.
+	CollisionQualify CollisionStrategy = "qualify"
+)
+
+// ParseCollisionStrategy parses the name of a [CollisionStrategy].
+func ParseCollisionStrategy(s string) (CollisionStrategy, error) {
+	switch strategy := CollisionStrategy(s); strategy {
+	case CollisionSuffix, CollisionQualify:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown collision strategy %q, want one of suffix or qualify", s)
+}
+
+// A Rename records an identifier that was renamed to resolve a collision.
+//
+// Path: The source of the renamed identifier, either the path of a field or the file of a table.
+//
+// Field renames are reported with the Table they belong to, table renames are not.
+type Rename struct {
+	Table string
+	Path  string
+	From  string
+	To    string
+}
+
+// String formats the [Rename] as a single human readable line.
+func (r Rename) String() string {
+	if r.Table == "" {
+		return fmt.Sprintf("%s: table renamed from %s to %s to avoid a collision", r.Path, r.From, r.To)
+	}
+	return fmt.Sprintf("%s: %s renamed from %s to %s to avoid a collision", r.Table, r.Path, r.From, r.To)
+}
+
+// ResolveFieldCollisions renames any fields of the [Table] that are named the same target column by its [NamingConvention],
+// or by the prefixes of its [IdentifierStrategy].
+// Within each collision, columns of the table are preferred over fields unpacked from JSON, and then the field with
+// the lowest path keeps its name, so the resolution is deterministic.
+func ResolveFieldCollisions(t *Table, strategy CollisionStrategy) []Rename {
+	renames := []Rename{}
+	collisions := make(map[string][]string)
+	taken := make(map[string]bool)
+	for path, field := range t.Fields {
+		node := t.targetName(field.Node)
+		collisions[node] = append(collisions[node], path)
+		taken[node] = true
+	}
+
+	nodes := maps.Keys(collisions)
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		paths := collisions[node]
+		sort.Slice(paths, func(i, j int) bool {
+			iUnpacked, jUnpacked := strings.Contains(paths[i], ":"), strings.Contains(paths[j], ":")
+			if iUnpacked != jUnpacked {
+				return jUnpacked
+			}
+			return paths[i] < paths[j]
+		})
+		for _, path := range paths[1:] {
+			renamed := ""
+			if strategy == CollisionQualify {
+				renamed = t.qualifiedNode(path)
+			}
+			if renamed == "" || taken[renamed] {
+				renamed = suffixedName(node, taken, t.Naming.MaxLength)
+			}
+			taken[renamed] = true
+
+			field := t.Fields[path]
+			field.Node = renamed
+			t.Fields[path] = field
+			renames = append(renames, Rename{Table: t.Name, Path: field.Path, From: node, To: renamed})
+		}
+	}
+	return renames
+}
+
+// ResolveTableCollisions renames any [Table]s that clean to the same table name.
+// Tables keep their names in the order given, so later tables are the ones renamed.
+// Only the models of a renamed table are renamed: it keeps reading from the source table named before the rename.
+func ResolveTableCollisions(tables []*Table) []Rename {
+	renames := []Rename{}
+	taken := make(map[string]bool)
+	for _, table := range tables {
+		if taken[table.Name] {
+			_, name := table.source()
+			renamed := suffixedName(table.Name, taken, 0)
+			renames = append(renames, Rename{Path: table.File, From: table.Name, To: renamed})
+			table.Name = renamed
+			table.SourceTable = name
+		}
+		taken[table.Name] = true
+	}
+	return renames
+}
+
+// targetName returns the target column of a field named node, following the [NamingConvention] of the [Table].
+// Where the Table's identifiers are prefixed, names that aren't valid unquoted are prefixed just as they are in the models,
+// so fields that only collide once prefixed, such as order and _order, are told apart too.
+func (t *Table) targetName(node string) string {
+	name := t.Naming.Name(node)
+	if t.identifiers != IdentifierPrefix {
+		return name
+	}
+	prefixed, _ := t.dialect.SafeIdentifier(name, IdentifierPrefix)
+	return t.Naming.Name(prefixed)
+}
+
+// qualifiedNode returns the target column of a field unpacked from JSON, qualified by the column it was unpacked from.
+// It returns an empty string for fields that weren't unpacked.
+func (t *Table) qualifiedNode(path string) string {
+	if !strings.Contains(path, ":") {
+		return ""
+	}
+	return t.targetName(strings.ReplaceAll(path, ":", "."))
+}
+
+// suffixedName returns the name with the lowest numeric suffix, starting from 2, that isn't already taken.
+// Where the suffix would take the name past the maximum length, the name is shortened to make room for it,
+// so a suffixed name is left unchanged by the [NamingConvention] that named it. A maximum length of zero places no limit.
+func suffixedName(name string, taken map[string]bool, maxLength int) string {
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		base := name
+		if maxLength > 0 && len(base)+len(suffix) > maxLength {
+			keep := maxLength - len(suffix)
+			if keep < 0 {
+				keep = 0
+			}
+			base = strings.TrimRight(base[:keep], "_")
+		}
+		suffixed := base + suffix
+		if !taken[suffixed] {
+			return suffixed
+		}
+	}
+}
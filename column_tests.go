@@ -0,0 +1,132 @@
+package templater
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A TestMode determines how suggested DBT tests are emitted in the transform models.
+type TestMode string
+
+const (
+	// TestsOff suggests no tests.
+	TestsOff TestMode = "off"
+	// TestsError emits suggested tests with DBT's default severity of error.
+	TestsError TestMode = "error"
+	// TestsWarn emits suggested tests with a severity of warn, so failures don't fail the build.
+	TestsWarn TestMode = "warn"
+	// TestsCommented emits suggested tests commented out, for a human to review and enable.
+	TestsCommented TestMode = "commented"
+)
+
+// ParseTestMode parses the name of a [TestMode].
+func ParseTestMode(s string) (TestMode, error) {
+	switch mode := TestMode(s); mode {
+	case TestsOff, TestsError, TestsWarn, TestsCommented:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown test mode %q, want one of off, error, warn or commented", s)
+}
+
+// A TestPolicy decides which DBT tests are suggested for a column, based on the values observed during inference.
+//
+// Mode: How the suggested tests are emitted. The zero value suggests no tests.
+//
+// Confidence: The fraction of observed rows that must satisfy a test before it is suggested, from 0 to 1.
+//
+// MinRows: The number of rows that must have been observed before any test is suggested for a table.
+//
+// MaxAcceptedValues: The number of distinct values at or below which a STRING column is considered an enumeration,
+// and given an accepted_values test listing the observed values. Zero suggests no accepted_values tests.
+//
+// Reference: https://docs.getdbt.com/reference/resource-properties/tests.
+type TestPolicy struct {
+	Mode              TestMode
+	Confidence        float64
+	MinRows           int
+	MaxAcceptedValues int
+}
+
+// enabled reports whether the [TestPolicy] suggests any tests at all.
+func (p TestPolicy) enabled() bool {
+	return p.Mode != "" && p.Mode != TestsOff
+}
+
+// columnTest renders a generic DBT test in a form suitable for the tests of a [Column].
+// Tests without arguments are rendered as just their name, unless the policy requires a severity.
+func (p TestPolicy) columnTest(name string, args map[string]any) any {
+	if p.Mode == TestsWarn {
+		if args == nil {
+			args = make(map[string]any)
+		}
+		args["config"] = map[string]any{"severity": "warn"}
+	}
+	if args == nil {
+		return name
+	}
+	return map[string]any{name: args}
+}
+
+// suggestTests returns the not_null, unique and accepted_values tests supported by the observed values of a [Field].
+// PII fields are never given accepted_values tests, which would list their values.
+func (p TestPolicy) suggestTests(field Field, rows int) []any {
+	if !p.enabled() || field.Stats == nil || rows == 0 || rows < p.MinRows {
+		return nil
+	}
+	tests := []any{}
+	stats := field.Stats
+	if stats.NonNull > 0 && float64(stats.NonNull)/float64(rows) >= p.Confidence {
+		tests = append(tests, p.columnTest("not_null", nil))
+	}
+	distinct, exact := stats.Distinct()
+	uniqueable := field.InferredType != "BOOLEAN" && field.InferredType != "ARRAY"
+	if exact && uniqueable && stats.NonNull > 0 && float64(distinct)/float64(stats.NonNull) >= p.Confidence {
+		tests = append(tests, p.columnTest("unique", nil))
+	}
+	values, complete := stats.Values()
+	enumerable := field.InferredType == "STRING" && field.PII == "" && complete && len(values) > 0
+	if enumerable && len(values) <= p.MaxAcceptedValues {
+		tests = append(tests, p.columnTest("accepted_values", map[string]any{"values": values}))
+	}
+	if len(tests) == 0 {
+		return nil
+	}
+	return tests
+}
+
+// relationshipTests returns a relationships test for each of the [Relationship]s from a column.
+// Relationships point at the transform model of the referenced table, with the referenced column rendered by columnSQL.
+func (p TestPolicy) relationshipTests(relationships []Relationship, rows int, columnSQL func(string) string) []any {
+	if !p.enabled() || len(relationships) == 0 || rows == 0 || rows < p.MinRows {
+		return nil
+	}
+	tests := []any{}
+	for _, relationship := range relationships {
+		tests = append(tests, p.columnTest("relationships", map[string]any{
+			"to":    fmt.Sprintf("ref('TRANS01_%s')", relationship.To.Table),
+			"field": columnSQL(relationship.To.Column),
+		}))
+	}
+	return tests
+}
+
+// columnTestsIndent is the indentation of the tests of a column in an encoded _models_schema.yml.
+const columnTestsIndent = "        "
+
+// commentOutColumnTests comments out the tests of every column in an encoded _models_schema.yml,
+// leaving them in place for a human to review.
+func commentOutColumnTests(encoded []byte) []byte {
+	lines := strings.Split(string(encoded), "\n")
+	inTests := false
+	for i, line := range lines {
+		if inTests && strings.HasPrefix(line, columnTestsIndent+" ") {
+			lines[i] = columnTestsIndent + "# " + strings.TrimPrefix(line, columnTestsIndent)
+			continue
+		}
+		inTests = line == columnTestsIndent+"tests:"
+		if inTests {
+			lines[i] = columnTestsIndent + "# tests:"
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
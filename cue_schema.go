@@ -0,0 +1,179 @@
+package templater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/literal"
+)
+
+// cueTypes maps the Snowflake type of a [Field] to the CUE type of its values.
+// FLOAT columns may hold integers too, so they are any number.
+var cueTypes = map[string]string{
+	"STRING":  "string",
+	"VARCHAR": "string",
+	"INTEGER": "int",
+	"FLOAT":   "number",
+	"BOOLEAN": "bool",
+	"OBJECT":  "{...}",
+	"ARRAY":   "[...]",
+}
+
+// A cueNode is a field of a CUE definition: either a [Field] of a [Table], a column of JSON along with the fields unpacked from it,
+// or the struct of a row.
+type cueNode struct {
+	field    *Field
+	unpacked []*Field
+	children map[string]*cueNode
+}
+
+// GenerateCUEDefinition generates a CUE definition of the rows of the [Table], such as #ORDERS, to validate future exports of it with cue vet.
+// Each column is typed as it was inferred:
+//   - Fields that were null in any row are optional, and may be null.
+//   - STRING fields with at most maxEnumValues distinct values, seen more than once, may only be one of those values, unless they hold PII.
+//   - Columns that JSON was unpacked from are strings, as they are in the CSV, with a comment naming the fields unpacked from them.
+//
+// The definition is closed, so a row holding a field that wasn't inferred fails validation.
+func GenerateCUEDefinition(table *Table, maxEnumValues int, unpackPaths ...string) string {
+	root := &cueNode{children: make(map[string]*cueNode)}
+	for key := range table.Fields {
+		field := table.Fields[key]
+		column, unpacked := cueColumn(key, unpackPaths)
+		label := cueLabel(column)
+		node, ok := root.children[label]
+		if !ok {
+			node = &cueNode{}
+			root.children[label] = node
+		}
+		if unpacked {
+			node.unpacked = append(node.unpacked, &field)
+			continue
+		}
+		node.field = &field
+	}
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "// #%s is a row of %s.\n", table.Name, table.File)
+	fmt.Fprintf(b, "#%s: ", table.Name)
+	root.write(b, table.Rows, maxEnumValues)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// cueColumn returns the column of a row a [Field] belongs to, given its key in the fields of its [Table],
+// and reports whether the field was unpacked from the JSON in that column.
+// Fields unpacked from JSON are keyed by the column they were unpacked from and their path within its JSON, separated by a colon.
+func cueColumn(key string, unpackPaths []string) (string, bool) {
+	for _, unpackPath := range unpackPaths {
+		if strings.HasPrefix(key, unpackPath+":") {
+			return unpackPath, true
+		}
+	}
+	return key, false
+}
+
+// cueLabel returns the label of a column in a CUE definition, quoting it if it isn't a valid identifier.
+func cueLabel(column string) string {
+	path := cue.ParsePath(column)
+	if path.Err() != nil || len(path.Selectors()) != 1 {
+		return cue.Str(column).String()
+	}
+	return path.Selectors()[0].String()
+}
+
+// write writes the CUE type of the node, given the number of rows inferred from.
+func (n *cueNode) write(b *strings.Builder, rows int, maxEnumValues int) {
+	if n.children == nil {
+		if n.field != nil {
+			b.WriteString(n.field.cueType(maxEnumValues))
+		} else {
+			b.WriteString("string")
+		}
+		if n.nullable(rows) {
+			b.WriteString(" | null")
+		}
+		return
+	}
+	labels := make([]string, 0, len(n.children))
+	for label := range n.children {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	b.WriteString("{\n")
+	for _, label := range labels {
+		child := n.children[label]
+		if len(child.unpacked) > 0 {
+			fmt.Fprintf(b, "// JSON, unpacked into %s.\n", child.unpackedNodes())
+		}
+		b.WriteString(label)
+		if child.nullable(rows) {
+			b.WriteString("?")
+		}
+		b.WriteString(": ")
+		child.write(b, rows, maxEnumValues)
+		b.WriteString("\n")
+	}
+	b.WriteString("}")
+}
+
+// nullable reports whether the node was null in any of the rows.
+// A column of JSON may have been whenever every field unpacked from it was, as they all are in a row where the column is null.
+func (n *cueNode) nullable(rows int) bool {
+	if n.field != nil {
+		return n.field.Stats == nil || n.field.Stats.Nulls(rows) > 0
+	}
+	for _, field := range n.unpacked {
+		if field.Stats != nil && field.Stats.Nulls(rows) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// unpackedNodes lists the target columns of the fields unpacked from a column of JSON, in sorted order.
+func (n *cueNode) unpackedNodes() string {
+	nodes := make([]string, 0, len(n.unpacked))
+	for _, field := range n.unpacked {
+		nodes = append(nodes, field.Node)
+	}
+	sort.Strings(nodes)
+	return strings.Join(nodes, ", ")
+}
+
+// cueType returns the CUE type of the values of the [Field], or a disjunction of its values if it is an enumeration.
+// Fields holding PII are never enumerated, so their values stay out of the definition.
+func (f Field) cueType(maxEnumValues int) string {
+	if f.InferredType == "STRING" && f.Stats != nil && f.PII == "" {
+		values, complete := f.Stats.Values()
+		if complete && len(values) > 0 && len(values) <= maxEnumValues && len(values) < f.Stats.NonNull {
+			quoted := make([]string, 0, len(values))
+			for _, value := range values {
+				quoted = append(quoted, literal.String.Quote(value))
+			}
+			return strings.Join(quoted, " | ")
+		}
+	}
+	if t, ok := cueTypes[f.InferredType]; ok {
+		return t
+	}
+	return "_"
+}
+
+// writeCUESchema writes the CUE definition of each [Table] to the [Sink] as schema.cue, sorted by the names of the tables.
+func writeCUESchema(sink Sink, tables []*Table, maxEnumValues int, unpackPaths ...string) error {
+	sorted := append([]*Table{}, tables...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	definitions := []string{}
+	for _, table := range sorted {
+		definitions = append(definitions, GenerateCUEDefinition(table, maxEnumValues, unpackPaths...))
+	}
+	formatted, err := format.Source([]byte(strings.Join(definitions, "\n")))
+	if err != nil {
+		return err
+	}
+	return sink.WriteFile("schema.cue", formatted)
+}
@@ -0,0 +1,145 @@
+package templater
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"golang.org/x/exp/maps"
+)
+
+// landingStage is the name of the internal stage, in each source schema, that the CSVs of its tables are put in to be loaded.
+const landingStage = "LANDING"
+
+// GenerateLandingDDL generates the Snowflake DDL to land the CSV of the [Table] in its source schema, as its source relation expects:
+//   - A file format matching the CSV as it was read: its line endings, header, quoting and null values.
+//   - An internal stage for the CSVs of the schema, which the CSV is to be put in.
+//   - The raw table, with a VARCHAR column for each column of the CSV, or a VARIANT column for the columns to unpack JSON from.
+//   - A COPY INTO statement loading the CSV from the stage into the table, parsing the JSON of the VARIANT columns.
+//
+// Reference: https://docs.snowflake.com/en/sql-reference/sql/copy-into-table.html.
+func GenerateLandingDDL(table *Table, unpackPaths ...string) string {
+	schema := landingIdentifier(table.sourceSchema())
+	_, name := table.source()
+	relation := fmt.Sprintf("%s.%s", schema, landingIdentifier(name))
+	format := fmt.Sprintf("%s.%s", schema, landingIdentifier(name+"_CSV"))
+	stage := fmt.Sprintf("%s.%s", schema, landingStage)
+
+	variant := make(map[string]bool)
+	for _, unpackPath := range unpackPaths {
+		variant[columnOf(cue.ParsePath(unpackPath))] = true
+	}
+	columns, selects, definitions := []string{}, []string{}, []string{}
+	for i, column := range table.format.Columns {
+		column = quoteIdentifier(column)
+		columns = append(columns, column)
+		if variant[cue.Str(table.format.Columns[i]).String()] {
+			selects = append(selects, fmt.Sprintf("PARSE_JSON($%d)", i+1))
+			definitions = append(definitions, fmt.Sprintf("  %s VARIANT", column))
+			continue
+		}
+		selects = append(selects, fmt.Sprintf("$%d", i+1))
+		definitions = append(definitions, fmt.Sprintf("  %s VARCHAR", column))
+	}
+
+	nulls := []string{"''"}
+	for _, null := range sortedNanValues() {
+		nulls = append(nulls, fmt.Sprintf("'%s'", null))
+	}
+	recordDelimiter := `\n`
+	if table.format.CRLF {
+		recordDelimiter = `\r\n`
+	}
+
+	ddl := new(strings.Builder)
+	fmt.Fprintf(ddl, "CREATE SCHEMA IF NOT EXISTS %s;\n\n", schema)
+	if table.format.LazyQuotes {
+		fmt.Fprintf(ddl, "-- The quoting of %s is malformed, and templater read it leniently. Check that it loads as expected.\n", table.File)
+	}
+	fmt.Fprintf(ddl, "CREATE OR REPLACE FILE FORMAT %s\n", format)
+	fmt.Fprintf(ddl, "  TYPE = CSV\n")
+	fmt.Fprintf(ddl, "  FIELD_DELIMITER = ','\n")
+	fmt.Fprintf(ddl, "  RECORD_DELIMITER = '%s'\n", recordDelimiter)
+	fmt.Fprintf(ddl, "  SKIP_HEADER = 1\n")
+	fmt.Fprintf(ddl, "  FIELD_OPTIONALLY_ENCLOSED_BY = '\"'\n")
+	fmt.Fprintf(ddl, "  NULL_IF = (%s)\n", strings.Join(nulls, ", "))
+	fmt.Fprintf(ddl, "  EMPTY_FIELD_AS_NULL = TRUE;\n\n")
+	fmt.Fprintf(ddl, "CREATE STAGE IF NOT EXISTS %s;\n\n", stage)
+	fmt.Fprintf(ddl, "CREATE TABLE IF NOT EXISTS %s (\n%s\n);\n\n", relation, strings.Join(definitions, ",\n"))
+	fmt.Fprintf(ddl, "COPY INTO %s (%s)\n", relation, strings.Join(columns, ", "))
+	fmt.Fprintf(ddl, "  FROM (SELECT %s FROM @%s/%s)\n", strings.Join(selects, ", "), stage, path.Base(table.File))
+	fmt.Fprintf(ddl, "  FILE_FORMAT = (FORMAT_NAME = '%s');\n", format)
+	return ddl.String()
+}
+
+// landingIdentifier returns the name of a schema or table as it appears in the landing DDL,
+// quoted if it isn't valid unquoted in Snowflake, just as the sources of the models quote it.
+func landingIdentifier(name string) string {
+	if Snowflake.NeedsQuoting(name) {
+		return Snowflake.Quote(name)
+	}
+	return name
+}
+
+// quoteIdentifier quotes a column name of a CSV as a Snowflake identifier, so that it keeps its case and any special characters.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sortedNanValues returns the CSV fields read as nulls, in sorted order.
+func sortedNanValues() []string {
+	values := make([]string, 0, len(nanValues))
+	for value := range nanValues {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// writeLandingDDL writes the landing DDL of each [Table] to the [Sink], as ddl/TABLE.sql.
+// Tables in subdirectories of the project are written to the matching subdirectories of ddl.
+func writeLandingDDL(sink Sink, tables []*Table, unpackPaths ...string) error {
+	for _, table := range tables {
+		dir := path.Dir(table.File)
+		err := sink.WriteFile(path.Join("ddl", dir, fmt.Sprintf("%s.sql", table.Name)), []byte(GenerateLandingDDL(table, unpackPaths...)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateTargetDDL generates the DDL creating the typed target table of the transform model of the [Table],
+// for those enforcing its types without DBT contracts.
+// Columns are named, typed and sorted just as in the model, in the [Dialect] given by [WithDialect],
+// with PII columns masked by [WithMasking] typed as the hash the masking macro returns.
+// The table is quoted if its name isn't valid unquoted in the Dialect.
+func GenerateTargetDDL(table *Table, opts ...ModelOption) string {
+	config := newModelConfig(opts...)
+	fields := maps.Values(table.Fields)
+	config.order.sortFields(fields)
+	definitions := make([]string, 0, len(fields))
+	for _, field := range fields {
+		definitions = append(definitions, fmt.Sprintf("  %s %s", config.columnSQL(field.Node), config.dataType(field)))
+	}
+	name := "TRANS01_" + table.Name
+	if config.dialect.NeedsQuoting(name) {
+		name = config.dialect.Quote(name)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n);\n", name, strings.Join(definitions, ",\n"))
+}
+
+// writeTargetDDL writes the target DDL of each [Table] to the [Sink], as ddl/transform/TRANS01_TABLE.sql.
+// Tables in subdirectories of the project are written to the matching subdirectories of ddl/transform.
+func writeTargetDDL(sink Sink, tables []*Table, opts ...ModelOption) error {
+	for _, table := range tables {
+		dir := path.Dir(table.File)
+		err := sink.WriteFile(path.Join("ddl", "transform", dir, fmt.Sprintf("TRANS01_%s.sql", table.Name)), []byte(GenerateTargetDDL(table, opts...)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package templater
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Severity ranks how much a [Diagnostic] deserves attention.
+type Severity string
+
+const (
+	// SeverityInfo is something the data didn't support, which was skipped as expected.
+	SeverityInfo Severity = "info"
+	// SeverityWarning is a fallback that may have left the generated project wrong.
+	SeverityWarning Severity = "warning"
+	// SeverityNever is never reached by a diagnostic, so failing at it never fails a run.
+	SeverityNever Severity = "never"
+)
+
+// ParseSeverity parses the name of a [Severity] to fail a run at.
+func ParseSeverity(s string) (Severity, error) {
+	switch severity := Severity(s); severity {
+	case SeverityInfo, SeverityWarning, SeverityNever:
+		return severity, nil
+	}
+	return "", fmt.Errorf("unknown severity %q, want one of info, warning or never", s)
+}
+
+// rank orders the [Severity]s from least to most severe.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	}
+	return 3
+}
+
+// AtLeast reports whether the [Severity] is at least as severe as the other.
+func (s Severity) AtLeast(other Severity) bool {
+	return s.rank() >= other.rank()
+}
+
+// A DiagnosticCode identifies the kind of a [Diagnostic].
+type DiagnosticCode string
+
+const (
+	// MalformedQuotes is a CSV whose quoting is invalid, read with lazy quotes instead.
+	MalformedQuotes DiagnosticCode = "malformed_quotes"
+	// MissingUnpackColumn is a column to unpack JSON from that isn't in the table.
+	MissingUnpackColumn DiagnosticCode = "missing_unpack_column"
+	// NullUnpackValue is a null or empty value in a column to unpack JSON from, which had nothing to unpack.
+	NullUnpackValue DiagnosticCode = "null_unpack_value"
+	// InvalidUnpackJSON is a value in a column to unpack JSON from that isn't valid JSON, which was skipped.
+	InvalidUnpackJSON DiagnosticCode = "invalid_unpack_json"
+	// ArraySkipped is a field nested in a JSON array, which isn't unpacked.
+	ArraySkipped DiagnosticCode = "array_skipped"
+	// EmptyCSV is a CSV with a header but no rows, so every column fell back to VARCHAR.
+	EmptyCSV DiagnosticCode = "empty_csv"
+	// AllNull is a field whose every value was null, so its type fell back to VARCHAR.
+	AllNull DiagnosticCode = "all_null"
+)
+
+// A Diagnostic records something that degraded a run without failing it.
+//
+// Line: The line of the File the diagnostic was first seen on, or zero where it isn't about a single line.
+//
+// Path: The source path of the field the diagnostic is about, if any.
+//
+// Excerpt: The source the diagnostic was first seen in, such as the offending value.
+//
+// Count: The number of times the diagnostic was seen. Only the first is kept, with its Line and Excerpt.
+type Diagnostic struct {
+	Severity Severity       `json:"severity"`
+	Code     DiagnosticCode `json:"code"`
+	File     string         `json:"file,omitempty"`
+	Line     int            `json:"line,omitempty"`
+	Path     string         `json:"path,omitempty"`
+	Message  string         `json:"message"`
+	Excerpt  string         `json:"excerpt,omitempty"`
+	Count    int            `json:"count"`
+}
+
+// String formats the [Diagnostic] like a compiler message, followed by its excerpt on an indented line.
+func (d Diagnostic) String() string {
+	location := d.File
+	if d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	s := fmt.Sprintf("%s: %s: ", location, d.Severity)
+	if d.Path != "" {
+		s += d.Path + ": "
+	}
+	s += d.Message
+	if d.Count > 1 {
+		s += fmt.Sprintf(" (seen %d times)", d.Count)
+	}
+	if d.Excerpt != "" {
+		s += "\n\t| " + d.Excerpt
+	}
+	return s
+}
+
+// maxExcerptLength is the longest an excerpt of a [Diagnostic] may be before it is cut short.
+const maxExcerptLength = 80
+
+// excerpt cuts a source excerpt short, and keeps it to a single line.
+func excerpt(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > maxExcerptLength {
+		return string(runes[:maxExcerptLength]) + "..."
+	}
+	return s
+}
+
+// Diagnostics collects the [Diagnostic]s of a run. It is safe to use from the workers inferring each table at once.
+// Repeats of a diagnostic, with the same file, code and path, are counted rather than kept.
+// A nil *Diagnostics discards everything added to it.
+type Diagnostics struct {
+	mu          sync.Mutex
+	diagnostics []Diagnostic
+	seen        map[string]int
+}
+
+// Add adds the [Diagnostic] to the collection, or counts it if it has been seen before.
+func (d *Diagnostics) Add(diagnostic Diagnostic) {
+	diagnostic.Count = 1
+	d.add(diagnostic)
+}
+
+// merge adds each of the [Diagnostic]s of another collection, keeping their counts.
+func (d *Diagnostics) merge(diagnostics []Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		d.add(diagnostic)
+	}
+}
+
+// add adds the [Diagnostic] to the collection, or adds its count to that of the one seen before.
+func (d *Diagnostics) add(diagnostic Diagnostic) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]int)
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%s", diagnostic.File, diagnostic.Code, diagnostic.Path)
+	if i, ok := d.seen[key]; ok {
+		d.diagnostics[i].Count += diagnostic.Count
+		return
+	}
+	d.seen[key] = len(d.diagnostics)
+	d.diagnostics = append(d.diagnostics, diagnostic)
+}
+
+// List returns the [Diagnostic]s collected, sorted by file, line, code and path so that they are stable from run to run.
+func (d *Diagnostics) List() []Diagnostic {
+	if d == nil {
+		return []Diagnostic{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := append([]Diagnostic{}, d.diagnostics...)
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		return a.Path < b.Path
+	})
+	return list
+}
+
+// A DiagnosticsError fails a run with [Diagnostic]s at or above the [Severity] the run was to fail at.
+type DiagnosticsError struct {
+	Severity    Severity
+	Diagnostics []Diagnostic
+}
+
+func (e *DiagnosticsError) Error() string {
+	return fmt.Sprintf("%d diagnostics at or above %s severity", len(e.Diagnostics), e.Severity)
+}
+
+// failAt returns a [DiagnosticsError] if any of the [Diagnostic]s are at or above the [Severity].
+func failAt(severity Severity, diagnostics []Diagnostic) error {
+	failing := []Diagnostic{}
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity.AtLeast(severity) {
+			failing = append(failing, diagnostic)
+		}
+	}
+	if len(failing) > 0 {
+		return &DiagnosticsError{Severity: severity, Diagnostics: failing}
+	}
+	return nil
+}
+
+// diagnose adds a [Diagnostic] about the [Table] to its collection, at the line of the row currently being inferred.
+func (t *Table) diagnose(severity Severity, code DiagnosticCode, path, message, source string) {
+	t.diagnostics.Add(Diagnostic{
+		Severity: severity,
+		Code:     code,
+		File:     t.File,
+		Line:     t.line,
+		Path:     path,
+		Message:  message,
+		Excerpt:  excerpt(source),
+	})
+}
+
+// sourceLine returns the given line of the file of the [Table], for an excerpt, or an empty string if it can't be read.
+func (t *Table) sourceLine(ctx context.Context, line int) string {
+	source := ""
+	t.read(ctx, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			if n == line {
+				source = scanner.Text()
+				break
+			}
+		}
+		return nil
+	})
+	return source
+}
@@ -0,0 +1,188 @@
+package templater
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A Dialect describes the rules for identifiers in a SQL dialect, and the names of its data types.
+//
+// An unquoted identifier must start with a letter or an underscore, and must not be one of the dialect's reserved words.
+type Dialect struct {
+	Name     string
+	quote    string
+	reserved map[string]bool
+	fold     func(string) string
+	types    map[string]string
+}
+
+// reservedWords builds a set of reserved words from a space separated list.
+func reservedWords(words string) map[string]bool {
+	reserved := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		reserved[word] = true
+	}
+	return reserved
+}
+
+// Snowflake is the dialect templater generates by default.
+//
+// Reference: https://docs.snowflake.com/en/sql-reference/reserved-keywords.html.
+var Snowflake = Dialect{
+	Name:  "snowflake",
+	quote: `"`,
+	fold:  strings.ToUpper,
+	reserved: reservedWords(`
+		ACCOUNT ALL ALTER AND ANY AS BETWEEN BY CASE CAST CHECK COLUMN CONNECT CONNECTION CONSTRAINT CREATE CROSS
+		CURRENT CURRENT_DATE CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER DATABASE DELETE DISTINCT DROP ELSE EXISTS
+		FALSE FOLLOWING FOR FROM FULL GRANT GROUP GSCLUSTER HAVING ILIKE IN INCREMENT INNER INSERT INTERSECT INTO IS
+		ISSUE JOIN LATERAL LEFT LIKE LOCALTIME LOCALTIMESTAMP MINUS NATURAL NOT NULL OF ON OR ORDER ORGANIZATION
+		QUALIFY REGEXP REVOKE RIGHT RLIKE ROW ROWS SAMPLE SCHEMA SELECT SET SOME START TABLE TABLESAMPLE THEN TO
+		TRIGGER TRUE TRY_CAST UNION UNIQUE UPDATE USING VALUES VIEW WHEN WHENEVER WHERE WITH`),
+}
+
+// Postgres describes PostgreSQL, and the warehouses derived from it.
+//
+// Reference: https://www.postgresql.org/docs/current/sql-keywords-appendix.html.
+var Postgres = Dialect{
+	Name:  "postgres",
+	quote: `"`,
+	fold:  strings.ToLower,
+	reserved: reservedWords(`
+		ALL ANALYSE ANALYZE AND ANY ARRAY AS ASC ASYMMETRIC AUTHORIZATION BINARY BOTH CASE CAST CHECK COLLATE
+		COLLATION COLUMN CONCURRENTLY CONSTRAINT CREATE CROSS CURRENT_CATALOG CURRENT_DATE CURRENT_ROLE
+		CURRENT_SCHEMA CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER DEFAULT DEFERRABLE DESC DISTINCT DO ELSE END
+		EXCEPT FALSE FETCH FOR FOREIGN FREEZE FROM FULL GRANT GROUP HAVING ILIKE IN INITIALLY INNER INTERSECT INTO
+		IS ISNULL JOIN LATERAL LEADING LEFT LIKE LIMIT LOCALTIME LOCALTIMESTAMP NATURAL NOT NOTNULL NULL OFFSET ON
+		ONLY OR ORDER OUTER OVERLAPS PLACING PRIMARY REFERENCES RETURNING RIGHT SELECT SESSION_USER SIMILAR SOME
+		SYMMETRIC SYSTEM_USER TABLE TABLESAMPLE THEN TO TRAILING TRUE UNION UNIQUE USER USING VARIADIC VERBOSE
+		WHEN WHERE WINDOW WITH`),
+	types: map[string]string{
+		"STRING":  "text",
+		"VARCHAR": "text",
+		"INTEGER": "bigint",
+		"FLOAT":   "double precision",
+		"BOOLEAN": "boolean",
+		"OBJECT":  "jsonb",
+		"ARRAY":   "jsonb",
+	},
+}
+
+// BigQuery describes Google BigQuery's GoogleSQL.
+//
+// Reference: https://cloud.google.com/bigquery/docs/reference/standard-sql/lexical#reserved_keywords.
+var BigQuery = Dialect{
+	Name:  "bigquery",
+	quote: "`",
+	reserved: reservedWords(`
+		ALL AND ANY ARRAY AS ASC ASSERT_ROWS_MODIFIED AT BETWEEN BY CASE CAST COLLATE CONTAINS CREATE CROSS CUBE
+		CURRENT DEFAULT DEFINE DESC DISTINCT ELSE END ENUM ESCAPE EXCEPT EXCLUDE EXISTS EXTRACT FALSE FETCH
+		FOLLOWING FOR FROM FULL GROUP GROUPING GROUPS HASH HAVING IF IGNORE IN INNER INTERSECT INTERVAL INTO IS
+		JOIN LATERAL LEFT LIKE LIMIT LOOKUP MERGE NATURAL NEW NO NOT NULL NULLS OF ON OR ORDER OUTER OVER PARTITION
+		PRECEDING PROTO QUALIFY RANGE RECURSIVE RESPECT RIGHT ROLLUP ROWS SELECT SET SOME STRUCT TABLESAMPLE THEN
+		TO TREAT TRUE UNBOUNDED UNION UNNEST USING WHEN WHERE WINDOW WITH WITHIN`),
+	types: map[string]string{
+		"STRING":  "STRING",
+		"VARCHAR": "STRING",
+		"INTEGER": "INT64",
+		"FLOAT":   "FLOAT64",
+		"BOOLEAN": "BOOL",
+		"OBJECT":  "JSON",
+		"ARRAY":   "JSON",
+	},
+}
+
+// dialects are the [Dialect]s that can be chosen by name.
+var dialects = map[string]Dialect{
+	Snowflake.Name: Snowflake,
+	Postgres.Name:  Postgres,
+	BigQuery.Name:  BigQuery,
+}
+
+// ParseDialect returns the [Dialect] with the given name.
+func ParseDialect(s string) (Dialect, error) {
+	dialect, ok := dialects[strings.ToLower(s)]
+	if !ok {
+		return Dialect{}, fmt.Errorf("unknown dialect %q, want one of snowflake, postgres or bigquery", s)
+	}
+	return dialect, nil
+}
+
+// IsReserved reports whether the identifier is a reserved word in the [Dialect].
+func (d Dialect) IsReserved(identifier string) bool {
+	return d.reserved[strings.ToUpper(identifier)]
+}
+
+// unquotedIdentifier matches identifiers that are valid unquoted, reserved words aside.
+var unquotedIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// NeedsQuoting reports whether the identifier is invalid unquoted in the [Dialect],
+// either because it is a reserved word, because it doesn't start with a letter or an underscore,
+// or because it holds characters other than letters, digits, underscores and dollar signs.
+func (d Dialect) NeedsQuoting(identifier string) bool {
+	return d.IsReserved(identifier) || !unquotedIdentifier.MatchString(identifier)
+}
+
+// PreservesCase reports whether the identifier keeps its case unquoted in the [Dialect].
+// Snowflake folds unquoted identifiers to uppercase, and Postgres folds them to lowercase.
+func (d Dialect) PreservesCase(identifier string) bool {
+	return d.fold == nil || d.fold(identifier) == identifier
+}
+
+// Quote returns the identifier as a delimited identifier in the [Dialect].
+func (d Dialect) Quote(identifier string) string {
+	quote := d.quote
+	if quote == "" {
+		quote = `"`
+	}
+	return quote + strings.ReplaceAll(identifier, quote, quote+quote) + quote
+}
+
+// DataType returns the name in the [Dialect] of the data type of a [Field], given the Snowflake type it was inferred as.
+// Types without a counterpart in the Dialect, and every type in Snowflake, keep their Snowflake name.
+func (d Dialect) DataType(inferredType string) string {
+	if dataType, ok := d.types[inferredType]; ok {
+		return dataType
+	}
+	return inferredType
+}
+
+// dialect returns the [Dialect] of the [Options], which is [Snowflake] unless another is given.
+func (o Options) dialect() Dialect {
+	if o.Dialect.Name == "" {
+		return Snowflake
+	}
+	return o.Dialect
+}
+
+// An IdentifierStrategy determines how identifiers that are invalid unquoted in a [Dialect] are made valid.
+type IdentifierStrategy string
+
+const (
+	// IdentifierQuote quotes the identifier, keeping its name.
+	IdentifierQuote IdentifierStrategy = "quote"
+	// IdentifierPrefix prefixes the identifier with an underscore, so that it is valid unquoted.
+	IdentifierPrefix IdentifierStrategy = "prefix"
+)
+
+// ParseIdentifierStrategy parses the name of an [IdentifierStrategy].
+func ParseIdentifierStrategy(s string) (IdentifierStrategy, error) {
+	switch strategy := IdentifierStrategy(s); strategy {
+	case IdentifierQuote, IdentifierPrefix:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown identifier strategy %q, want one of quote or prefix", s)
+}
+
+// SafeIdentifier returns an identifier that is valid in the [Dialect], following the [IdentifierStrategy].
+// It reports whether the returned identifier must be quoted, as it must be whenever a prefix wouldn't make it valid.
+func (d Dialect) SafeIdentifier(identifier string, strategy IdentifierStrategy) (string, bool) {
+	if !d.NeedsQuoting(identifier) {
+		return identifier, false
+	}
+	if strategy == IdentifierPrefix && identifier != "" && !d.NeedsQuoting("_"+identifier) {
+		return "_" + identifier, false
+	}
+	return identifier, true
+}
@@ -0,0 +1,116 @@
+package templater
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/pkg/diff"
+)
+
+// A FileStatus describes how a generated file differs from the file already in the output directory.
+type FileStatus string
+
+const (
+	FileNew      FileStatus = "new"
+	FileModified FileStatus = "modified"
+	FileDeleted  FileStatus = "deleted"
+)
+
+// A FileChange is a single file that generating the project would change.
+//
+// Before and After hold the contents of the file already in the output directory, and the contents generated for it.
+// Before is nil for new files, and After is nil for deleted files.
+type FileChange struct {
+	Path   string
+	Status FileStatus
+	Before []byte
+	After  []byte
+}
+
+// DiffArtifacts compares the [Artifacts] against the files already in the output directory, given as an [fs.FS],
+// returning a [FileChange] for each file that would be created, modified or deleted, sorted by path.
+// Only the Artifacts and the files generated by a previous run, given by their paths, are compared:
+// generated files that are no longer among the Artifacts are deleted, and every other file is left alone, so never reported.
+// An output directory that doesn't exist yet is treated as empty.
+func DiffArtifacts(output fs.FS, artifacts Artifacts, generated ...string) ([]FileChange, error) {
+	existing := Artifacts{}
+	for _, path := range append(artifacts.Paths(), generated...) {
+		if _, ok := existing[path]; ok || !fs.ValidPath(path) {
+			continue
+		}
+		contents, err := fs.ReadFile(output, path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		existing[path] = contents
+	}
+	return diffArtifacts(existing, artifacts), nil
+}
+
+// diffArtifacts compares two sets of [Artifacts], returning a [FileChange] for each file that differs, sorted by path.
+func diffArtifacts(before, after Artifacts) []FileChange {
+	changes := []FileChange{}
+	for path, contents := range before {
+		updated, ok := after[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: path, Status: FileDeleted, Before: contents})
+		case !bytes.Equal(contents, updated):
+			changes = append(changes, FileChange{Path: path, Status: FileModified, Before: contents, After: updated})
+		}
+	}
+	for path, contents := range after {
+		if _, ok := before[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Status: FileNew, After: contents})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// dryRunProject writes a diff of the files the [Result] would change in the output directory to the io.Writer.
+// Just as writing the Result would, it compares only the files it generates and those the lockfile records the previous run generating.
+// The lockfile is written separately from the project, so it is left out.
+// It reports whether any files would change.
+func dryRunProject(result *Result, output string, lockfile string, w io.Writer) (bool, error) {
+	changes, err := DiffArtifacts(os.DirFS(output), result.Artifacts, generatedFiles(output, lockfile)...)
+	if err != nil {
+		return false, err
+	}
+	err = WriteDiff(w, changes)
+	if err != nil {
+		return false, err
+	}
+	return len(changes) > 0, nil
+}
+
+// WriteDiff writes each [FileChange] to the io.Writer as a unified diff, followed by a count of the changes of each [FileStatus].
+func WriteDiff(w io.Writer, changes []FileChange) error {
+	counts := make(map[FileStatus]int)
+	for _, change := range changes {
+		counts[change.Status]++
+		before, after := "a/"+change.Path, "b/"+change.Path
+		switch change.Status {
+		case FileNew:
+			before = "/dev/null"
+		case FileDeleted:
+			after = "/dev/null"
+		}
+		err := diff.Text(before, after, change.Before, change.After, w)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d new, %d modified, %d deleted\n", counts[FileNew], counts[FileModified], counts[FileDeleted])
+	return err
+}
@@ -0,0 +1,143 @@
+package templater
+
+import (
+	"context"
+	"sort"
+
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// Artifacts are the rendered files of a generated project, keyed by their slash separated path within the output.
+// They are the in-memory [Sink].
+type Artifacts map[string][]byte
+
+// WriteFile adds a copy of the contents to the [Artifacts], so the caller may go on to reuse its buffer.
+func (a Artifacts) WriteFile(path string, contents []byte) error {
+	a[path] = append([]byte{}, contents...)
+	return nil
+}
+
+// Paths returns the paths of the [Artifacts] in sorted order.
+func (a Artifacts) Paths() []string {
+	paths := make([]string, 0, len(a))
+	for path := range a {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// A Result is everything generated by a run of [Generate].
+//
+// Tables: The inferred [Table]s.
+//
+// Renames: Each table or field renamed because its name collided with another.
+//
+// Artifacts: The rendered files of the project, such as the models and their properties.
+//
+// Lockfile: The snapshot of the inferred tables, for detecting drift in later runs.
+//
+// Diagnostics: Everything that degraded the inference without failing it.
+type Result struct {
+	Tables      []*Table
+	Renames     []Rename
+	Artifacts   Artifacts
+	Lockfile    Lockfile
+	Diagnostics []Diagnostic
+}
+
+// Generate given the [Options] for the run, will infer the tables of its Input and render the project in memory.
+// Nothing is printed and nothing but the cache of the CacheDir is written to disk, so it's up to the caller what to do with the [Result].
+// Inference stops early once the context is done, returning the context's error.
+// If there are [Diagnostic]s at or above the FailOn [Severity] of the Options, it returns a [DiagnosticsError].
+func Generate(ctx context.Context, opts Options) (*Result, error) {
+	return generate(ctx, opts, opts.tableCache())
+}
+
+// generate is [Generate], reusing the fields of any table whose file is unchanged in the [tableCache], if one is given,
+// rather than the one in the CacheDir of the [Options].
+func generate(ctx context.Context, opts Options, cache *tableCache) (*Result, error) {
+	diagnostics := &Diagnostics{}
+	tables, renames, err := inferProject(ctx, opts, diagnostics, cache)
+	if err != nil {
+		return nil, err
+	}
+	err = failAt(opts.FailOn, diagnostics.List())
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.detectsPII() {
+		DetectPII(tables)
+	}
+
+	modelOpts := []ModelOption{WithColumnTests(opts.Tests), WithMasking(opts.Masking), WithNaming(opts.Naming), WithColumnOrder(opts.Order), WithDialect(opts.dialect(), opts.Identifiers)}
+	if opts.Contracts {
+		modelOpts = append(modelOpts, WithContracts())
+	}
+	graph := EntityGraph{}
+	if opts.Relationships {
+		graph = InferEntityGraph(tables)
+		modelOpts = append(modelOpts, WithRelationships(graph.Relationships))
+	}
+	models := GenerateProjectModel(tables, modelOpts...)
+	sources := generateProjectSources(tables, opts.Project, modelOpts...)
+
+	artifacts := Artifacts{}
+	err = writeProject(artifacts, cuecontext.New(), opts, models, sources, tables, modelOpts...)
+	if err != nil {
+		return nil, err
+	}
+	err = writeMasking(artifacts, opts.Masking, tables)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Relationships {
+		err = writeEntityGraph(artifacts, graph)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Profile {
+		err = writeProfile(artifacts, tables)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.DDL {
+		err = writeLandingDDL(artifacts, tables, opts.UnpackPaths...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.TargetDDL {
+		err = writeTargetDDL(artifacts, tables, modelOpts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.CUE {
+		err = writeCUESchema(artifacts, tables, opts.CUEEnums, opts.UnpackPaths...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Result{
+		Tables:      tables,
+		Renames:     renames,
+		Artifacts:   artifacts,
+		Lockfile:    NewLockfile(tables),
+		Diagnostics: diagnostics.List(),
+	}, nil
+}
+
+// CopyTo writes each of the [Artifacts] to the [Sink], in the order of their paths.
+func (a Artifacts) CopyTo(sink Sink) error {
+	for _, path := range a.Paths() {
+		err := sink.WriteFile(path, a[path])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,12 +5,12 @@ go 1.18
 require (
 	cuelang.org/go v0.4.3
 	github.com/go-gota/gota v0.12.0
+	github.com/pkg/diff v0.0.0-20210226163009-20ebb0f2a09e
 	golang.org/x/exp v0.0.0-20221019170559-20944726eadf
 )
 
 require (
 	github.com/cockroachdb/apd/v2 v2.0.1 // indirect
-	github.com/pkg/diff v0.0.0-20210226163009-20ebb0f2a09e // indirect
 	golang.org/x/net v0.0.0-20210423184538-5f58ad60dda6 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	gonum.org/v1/gonum v0.9.1 // indirect
@@ -0,0 +1,263 @@
+package templater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/exp/maps"
+)
+
+// lockfileVersion is the version of the [Lockfile] format written by this package.
+const lockfileVersion = 1
+
+// A Lockfile is a persisted snapshot of the inferred [Table]s.
+// Comparing a fresh inference against a previous Lockfile reveals any schema drift in the source data.
+//
+// Output: The output directory the project was written to, if it was written to a directory.
+//
+// Files: The slash separated paths of the files generated in the Output, so that a later run can remove those it no longer generates.
+type Lockfile struct {
+	Version int           `json:"version"`
+	Tables  []LockedTable `json:"tables"`
+	Output  string        `json:"output,omitempty"`
+	Files   []string      `json:"files,omitempty"`
+}
+
+// A LockedTable is the snapshot of a single [Table] in a [Lockfile].
+type LockedTable struct {
+	Name   string        `json:"name"`
+	Fields []LockedField `json:"fields"`
+}
+
+// A LockedField is the snapshot of a single [Field] in a [LockedTable].
+type LockedField struct {
+	Path         string `json:"path"`
+	Node         string `json:"node"`
+	InferredType string `json:"inferred_type"`
+}
+
+// NewLockfile takes a snapshot of the given [Table]s.
+// Tables and fields are sorted so that the snapshot is stable from run to run.
+func NewLockfile(tables []*Table) Lockfile {
+	lockedTables := []LockedTable{}
+	for _, table := range tables {
+		fields := maps.Values(table.Fields)
+		lockedFields := make([]LockedField, 0, len(fields))
+		for _, field := range fields {
+			lockedFields = append(lockedFields, LockedField{
+				Path:         field.Path,
+				Node:         field.Node,
+				InferredType: field.InferredType,
+			})
+		}
+		sort.Slice(lockedFields, func(i, j int) bool {
+			return lockedFields[i].Path < lockedFields[j].Path
+		})
+		lockedTables = append(lockedTables, LockedTable{
+			Name:   table.Name,
+			Fields: lockedFields,
+		})
+	}
+	sort.Slice(lockedTables, func(i, j int) bool {
+		return lockedTables[i].Name < lockedTables[j].Name
+	})
+	return Lockfile{
+		Version: lockfileVersion,
+		Tables:  lockedTables,
+	}
+}
+
+// ReadLockfile reads a [Lockfile] previously written with [Lockfile.Write].
+func ReadLockfile(r io.Reader) (Lockfile, error) {
+	var l Lockfile
+	err := json.NewDecoder(r).Decode(&l)
+	if err != nil {
+		return Lockfile{}, err
+	}
+	if l.Version != lockfileVersion {
+		return Lockfile{}, fmt.Errorf("unsupported lockfile version %d", l.Version)
+	}
+	return l, nil
+}
+
+// Write writes the [Lockfile] to the io.Writer as indented JSON.
+func (l Lockfile) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(l)
+}
+
+// A ChangeKind describes how a table or field differs between two [Lockfile]s.
+type ChangeKind string
+
+const (
+	TableAdded   ChangeKind = "table added"
+	TableRemoved ChangeKind = "table removed"
+	FieldAdded   ChangeKind = "field added"
+	FieldRemoved ChangeKind = "field removed"
+	TypeChanged  ChangeKind = "type changed"
+)
+
+// A Change is a single difference between a previous and a current [Lockfile].
+//
+// Field is the escaped source path of the field, and is empty for table level changes.
+//
+// From and To hold the previous and current inferred types of a field whose type has changed.
+type Change struct {
+	Kind  ChangeKind
+	Table string
+	Field string
+	From  string
+	To    string
+}
+
+// Breaking reports whether the [Change] could break a downstream consumer.
+// Additions are considered safe, while removals and type changes are not.
+func (c Change) Breaking() bool {
+	return c.Kind != TableAdded && c.Kind != FieldAdded
+}
+
+// String formats the [Change] as a single human readable line.
+func (c Change) String() string {
+	switch c.Kind {
+	case TableAdded, TableRemoved:
+		return fmt.Sprintf("%s: %s", c.Table, c.Kind)
+	case TypeChanged:
+		return fmt.Sprintf("%s: %s %s from %s to %s", c.Table, c.Field, c.Kind, c.From, c.To)
+	default:
+		return fmt.Sprintf("%s: %s %s", c.Table, c.Field, c.Kind)
+	}
+}
+
+// DiffLockfiles compares a previous [Lockfile] with a current one and returns the [Change]s between them.
+// Changes are ordered by table, and then by field.
+func DiffLockfiles(previous, current Lockfile) []Change {
+	changes := []Change{}
+	previousTables := lockedTablesByName(previous)
+	currentTables := lockedTablesByName(current)
+
+	tableNames := append(maps.Keys(previousTables), maps.Keys(currentTables)...)
+	sort.Strings(tableNames)
+	for i, name := range tableNames {
+		if i > 0 && tableNames[i-1] == name {
+			continue
+		}
+		previousTable, inPrevious := previousTables[name]
+		currentTable, inCurrent := currentTables[name]
+		switch {
+		case !inCurrent:
+			changes = append(changes, Change{Kind: TableRemoved, Table: name})
+		case !inPrevious:
+			changes = append(changes, Change{Kind: TableAdded, Table: name})
+		default:
+			changes = append(changes, diffLockedFields(name, previousTable.Fields, currentTable.Fields)...)
+		}
+	}
+	return changes
+}
+
+// diffLockedFields compares the fields of a table that is present in both [Lockfile]s.
+func diffLockedFields(table string, previous, current []LockedField) []Change {
+	changes := []Change{}
+	previousFields := make(map[string]LockedField)
+	for _, field := range previous {
+		previousFields[field.Path] = field
+	}
+	currentFields := make(map[string]LockedField)
+	for _, field := range current {
+		currentFields[field.Path] = field
+	}
+
+	paths := append(maps.Keys(previousFields), maps.Keys(currentFields)...)
+	sort.Strings(paths)
+	for i, path := range paths {
+		if i > 0 && paths[i-1] == path {
+			continue
+		}
+		previousField, inPrevious := previousFields[path]
+		currentField, inCurrent := currentFields[path]
+		switch {
+		case !inCurrent:
+			changes = append(changes, Change{Kind: FieldRemoved, Table: table, Field: path})
+		case !inPrevious:
+			changes = append(changes, Change{Kind: FieldAdded, Table: table, Field: path})
+		case previousField.InferredType != currentField.InferredType:
+			changes = append(changes, Change{
+				Kind:  TypeChanged,
+				Table: table,
+				Field: path,
+				From:  previousField.InferredType,
+				To:    currentField.InferredType,
+			})
+		}
+	}
+	return changes
+}
+
+// lockedTablesByName indexes the tables of a [Lockfile] by their name.
+func lockedTablesByName(l Lockfile) map[string]LockedTable {
+	tables := make(map[string]LockedTable)
+	for _, table := range l.Tables {
+		tables[table.Name] = table
+	}
+	return tables
+}
+
+// writeLockfile writes the [Lockfile] to the given path, creating any missing parent directories.
+// It is written to a temporary file first and renamed into place, so a failed write leaves any previous lockfile untouched.
+func writeLockfile(path string, lockfile Lockfile) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	err = lockfile.Write(file)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// reportDrift compares the inferred [Table]s against the [Lockfile] at the given path,
+// writing each [Change] to the io.Writer.
+// It reports whether any of the changes are breaking.
+func reportDrift(path string, tables []*Table, w io.Writer) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	previous, err := ReadLockfile(file)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	breaking := 0
+	changes := DiffLockfiles(previous, NewLockfile(tables))
+	for _, change := range changes {
+		if change.Breaking() {
+			breaking++
+		}
+		fmt.Fprintln(w, change)
+	}
+	fmt.Fprintf(w, "%d changes, %d breaking\n", len(changes), breaking)
+	return breaking > 0, nil
+}
@@ -0,0 +1,145 @@
+package templater
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// A NameCase determines the case of the target column names produced by a [NamingConvention].
+type NameCase string
+
+const (
+	// CaseUpper produces SCREAMING_SNAKE_CASE names, splitting camelCase words.
+	CaseUpper NameCase = "upper"
+	// CaseLower produces snake_case names, splitting camelCase words.
+	CaseLower NameCase = "lower"
+	// CaseKeep keeps the case of the source, only replacing the characters that aren't valid in an identifier.
+	CaseKeep NameCase = "keep"
+)
+
+// ParseNameCase parses the name of a [NameCase].
+func ParseNameCase(s string) (NameCase, error) {
+	switch nameCase := NameCase(s); nameCase {
+	case CaseUpper, CaseLower, CaseKeep:
+		return nameCase, nil
+	}
+	return "", fmt.Errorf("unknown case %q, want one of upper, lower or keep", s)
+}
+
+// defaultSeparator joins the names of nested fields unless a [NamingConvention] says otherwise.
+const defaultSeparator = "__"
+
+// A NamingConvention derives the target column name of a [Field] from its source path.
+// The zero value is the convention of [NormaliseKey].
+//
+// Case: The [NameCase] of the names. The zero value is [CaseUpper].
+//
+// Separator: Joins the names of fields nested in JSON objects. The zero value is a double underscore.
+//
+// Abbreviations: Replaces whole words, matched regardless of case, such as "identifier" with "ID".
+//
+// MaxLength: The longest a name may be. Longer names are truncated, ending in a hash of the full name so they stay distinct.
+// The zero value places no limit on the length.
+//
+// Naming is idempotent, so names that have already been through the convention are unchanged by it.
+// That includes the names given to fields by [ResolveFieldCollisions], whose suffixes are kept within the MaxLength.
+type NamingConvention struct {
+	Case          NameCase
+	Separator     string
+	Abbreviations map[string]string
+	MaxLength     int
+}
+
+// mixedCaseCharacters are the characters kept by [CaseLower] and [CaseKeep], the counterpart to [validCharacters].
+var mixedCaseCharacters = regexp.MustCompile(`[A-Za-z0-9._ ]*`)
+
+// words matches each word of a name, between the underscores and dots that separate them.
+var words = regexp.MustCompile(`[^_.]+`)
+
+// Name derives the target column name of a source path, following the [NamingConvention].
+func (n NamingConvention) Name(s string) string {
+	separator := n.Separator
+	if separator == "" {
+		separator = defaultSeparator
+	}
+	s = strings.ReplaceAll(s, separator, ".")
+
+	characters := validCharacters
+	switch n.Case {
+	case CaseKeep:
+		characters = mixedCaseCharacters
+	case CaseLower:
+		s = camelCase.ReplaceAllString(s, `$1 $2 $3`)
+		s = strings.ToLower(s)
+		characters = mixedCaseCharacters
+	default:
+		s = camelCase.ReplaceAllString(s, `$1 $2 $3`)
+		s = strings.ToUpper(s)
+	}
+	s = strings.Join(characters.FindAllString(s, -1), " ")
+	s = strings.Join(strings.Fields(s), "_")
+	s = strings.Trim(s, ` `)
+	if len(n.Abbreviations) > 0 {
+		s = words.ReplaceAllStringFunc(s, n.abbreviate)
+	}
+	s = strings.ReplaceAll(s, `.`, separator)
+	return n.truncate(s)
+}
+
+// abbreviate returns the abbreviation of a word, in the case of the [NamingConvention], or the word itself if it has none.
+func (n NamingConvention) abbreviate(word string) string {
+	for long, short := range n.Abbreviations {
+		if !strings.EqualFold(long, word) {
+			continue
+		}
+		return n.applyCase(short)
+	}
+	return word
+}
+
+// applyCase converts a string to the case of the [NamingConvention].
+func (n NamingConvention) applyCase(s string) string {
+	switch n.Case {
+	case CaseKeep:
+		return s
+	case CaseLower:
+		return strings.ToLower(s)
+	}
+	return strings.ToUpper(s)
+}
+
+// truncate shortens a name longer than the MaxLength of the [NamingConvention],
+// replacing its end with a hash of the full name.
+func (n NamingConvention) truncate(s string) string {
+	if n.MaxLength <= 0 || len(s) <= n.MaxLength {
+		return s
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	hash := n.applyCase(fmt.Sprintf("%08x", h.Sum32()))
+	keep := n.MaxLength - len(hash) - 1
+	if keep <= 0 {
+		return hash[:n.MaxLength]
+	}
+	return strings.TrimRight(s[:keep], "_") + "_" + hash
+}
+
+// ParseAbbreviations parses a comma separated list of word=abbreviation pairs, such as "identifier=ID,number=NUM".
+func ParseAbbreviations(s string) (map[string]string, error) {
+	abbreviations := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		long, short, ok := strings.Cut(pair, "=")
+		long, short = strings.TrimSpace(long), strings.TrimSpace(short)
+		if !ok || long == "" || short == "" {
+			return nil, fmt.Errorf("invalid abbreviation %q, want word=abbreviation", pair)
+		}
+		abbreviations[long] = short
+	}
+	return abbreviations, nil
+}
@@ -0,0 +1,45 @@
+package templater
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A ColumnOrder determines the order of the columns of the generated models.
+type ColumnOrder string
+
+const (
+	// OrderAlphabetical sorts the columns by name. It is the default.
+	OrderAlphabetical ColumnOrder = "alphabetical"
+	// OrderSource mirrors the order of the columns in the source,
+	// with fields unpacked from JSON in place of their column, in the order they were first seen.
+	OrderSource ColumnOrder = "source"
+	// OrderGrouped mirrors the order of the columns in the source,
+	// with fields unpacked from JSON in place of their column, sorted by name.
+	OrderGrouped ColumnOrder = "grouped"
+)
+
+// ParseColumnOrder parses the name of a [ColumnOrder].
+func ParseColumnOrder(s string) (ColumnOrder, error) {
+	switch order := ColumnOrder(s); order {
+	case OrderAlphabetical, OrderSource, OrderGrouped:
+		return order, nil
+	}
+	return "", fmt.Errorf("unknown column order %q, want one of alphabetical, source or grouped", s)
+}
+
+// sortFields sorts the [Field]s in the [ColumnOrder], falling back to their names for fields of the same position.
+func (o ColumnOrder) sortFields(fields []Field) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if o == OrderSource || o == OrderGrouped {
+			if a.Ordinal != b.Ordinal {
+				return a.Ordinal < b.Ordinal
+			}
+		}
+		if o == OrderSource && a.seen != b.seen {
+			return a.seen < b.seen
+		}
+		return a.Node < b.Node
+	})
+}
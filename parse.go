@@ -28,43 +28,135 @@ var SnowflakeTypes = map[string]string{
 
 // InferFields takes a [cue.Iterator] and walks through it, adding fields to the table.
 // It will also unpack any JSON fields where the column name matches the (optional) unpackPath.
+// Each field records the position of its source column, in the order the columns appear in the rows.
 func (t *Table) InferFields(iter cue.Iterator, unpackPaths ...string) error {
 	for iter.Next() {
-		// if any, iterate through our raw VARIANTs and unpack them.
-		for _, unpackPath := range unpackPaths {
-			JSONString, err := lookupCuePath(iter.Value(), unpackPath)
-			if err != nil {
-				return err
-			}
-
-			unpackable, err := UnmarshalJSONFromCUE(JSONString)
-			if err != nil {
-				return err
-			}
-			unpackable.Walk(continueUnpacking, func(c cue.Value) { Unpack(t, c, func(s string) string { return fmt.Sprintf("%s:%s", unpackPath, s) }) })
+		err := t.InferRow(iter.Value(), unpackPaths...)
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		iter.Value().Walk(
-			func(c cue.Value) bool {
-				return true
-			},
-			func(c cue.Value) {
-				Unpack(t, c)
-			})
-		if len(t.Fields) == 0 {
-			return errors.New("empty JSON")
+// InferRow adds the fields of a single row to the table, as [Table.InferFields] does for each row it iterates over.
+// Rows can be inferred one at a time as they are read, so the whole table never needs to be held in memory.
+func (t *Table) InferRow(row cue.Value, unpackPaths ...string) error {
+	if t.Fields == nil {
+		t.Fields = make(map[string]Field)
+	}
+	t.Rows++
+	err := t.registerColumns(row)
+	if err != nil {
+		return err
+	}
+	// if any, iterate through our raw VARIANTs and unpack them.
+	// Anything that can't be unpacked is skipped, with a [Diagnostic] saying why.
+	for _, unpackPath := range unpackPaths {
+		JSONString, err := lookupCuePath(row, unpackPath)
+		if err != nil {
+			return err
+		}
+		if !JSONString.Exists() {
+			t.diagnose(SeverityWarning, MissingUnpackColumn, unpackPath, "column not found, so nothing was unpacked from it", "")
+			continue
+		}
+		if JSONString.IncompleteKind() == cue.NullKind {
+			t.diagnose(SeverityInfo, NullUnpackValue, unpackPath, "null value, so nothing was unpacked from it", "")
+			continue
+		}
+		if s, err := JSONString.String(); err == nil && strings.TrimSpace(s) == "" {
+			t.diagnose(SeverityInfo, NullUnpackValue, unpackPath, "empty value, so nothing was unpacked from it", "")
+			continue
 		}
 
-		// if any remove any of the raw VARIANT originals.
-		for _, unpackPath := range unpackPaths {
-			delete(t.Fields, unpackPath)
+		unpackable, err := UnmarshalJSONFromCUE(JSONString)
+		if err != nil {
+			source, _ := JSONString.String()
+			reason := strings.TrimPrefix(err.Error(), `invalid JSON for file "": `)
+			t.diagnose(SeverityWarning, InvalidUnpackJSON, unpackPath, fmt.Sprintf("invalid JSON, so it was skipped: %s", reason), source)
+			continue
 		}
+		column := columnOf(cue.ParsePath(unpackPath))
+		unpackable.Walk(func(c cue.Value) bool {
+			if continueUnpacking(c) {
+				return true
+			}
+			// Diagnose each array once, at its first element, rather than once for every element.
+			path := c.Path().String()
+			if !strings.HasSuffix(path, "[0]") {
+				return false
+			}
+			array := unpackPath
+			if i := strings.LastIndex(path, "["); i > 0 {
+				array = fmt.Sprintf("%s:%s", unpackPath, path[:i])
+			}
+			t.diagnose(SeverityInfo, ArraySkipped, EscapePath(array), "the elements of arrays are not unpacked, so the array is kept whole", "")
+			return false
+		}, func(c cue.Value) {
+			t.place(Unpack(t, c, func(s string) string { return fmt.Sprintf("%s:%s", unpackPath, s) }), column)
+		})
+	}
 
+	row.Walk(
+		func(c cue.Value) bool {
+			return true
+		},
+		func(c cue.Value) {
+			t.place(Unpack(t, c), columnOf(c.Path()))
+		})
+	if len(t.Fields) == 0 {
+		return errors.New("empty JSON")
 	}
 
+	// if any remove any of the raw VARIANT originals.
+	for _, unpackPath := range unpackPaths {
+		delete(t.Fields, unpackPath)
+	}
 	return nil
 }
 
+// registerColumns records the position of any columns of the row that haven't been seen before.
+func (t *Table) registerColumns(row cue.Value) error {
+	if t.columns == nil {
+		t.columns = make(map[string]int)
+	}
+	columns, err := row.Fields(cue.All())
+	if err != nil {
+		return err
+	}
+	for columns.Next() {
+		column := columns.Selector().String()
+		if _, ok := t.columns[column]; !ok {
+			t.columns[column] = len(t.columns)
+		}
+	}
+	return nil
+}
+
+// place records the position of a newly added [Field] from the position of its source column,
+// and the order in which it was first seen.
+func (t *Table) place(key, column string) {
+	field, ok := t.Fields[key]
+	if !ok || field.seen > 0 {
+		return
+	}
+	t.seen++
+	field.Ordinal = t.columns[column]
+	field.seen = t.seen
+	t.Fields[key] = field
+}
+
+// columnOf returns the top level column of a [cue.Path], skipping the index of the row.
+func columnOf(path cue.Path) string {
+	for _, selector := range path.Selectors() {
+		if selector.IsString() {
+			return selector.String()
+		}
+	}
+	return ""
+}
+
 // lookupCuePath attempts to find a child of a [cue.Value] at a given path.
 func lookupCuePath(c cue.Value, path string) (cue.Value, error) {
 	lookupPath := cue.ParsePath(path)
@@ -90,16 +182,17 @@ func UnmarshalJSONFromCUE(c cue.Value) (cue.Value, error) {
 }
 
 // Unpack constructs a [Field] from a [cue.Value] and adds it to the [Table].
-// A [NameOption] can be passed to modify the path of the field.
+// The target column is named by the [NamingConvention] of the table, and a [NameOption] can be passed to modify the path of the field.
 // Objects are recursively unpacked. Arrays are not.
-func Unpack(t *Table, c cue.Value, opts ...NameOption) {
+// It returns the key of the field in the table, or an empty string if no field was added.
+func Unpack(t *Table, c cue.Value, opts ...NameOption) string {
 	path := c.Path().String()
 	path = arrayAtLineStart.ReplaceAllString(path, "")
 	// If theres an array in this path, no need to unpack it.
 	if arrayInLine.MatchString(path) {
-		return
+		return ""
 	}
-	node := NormaliseKey(path)
+	node := t.Naming.Name(path)
 
 	for _, opt := range opts {
 		path = opt(path)
@@ -110,24 +203,22 @@ func Unpack(t *Table, c cue.Value, opts ...NameOption) {
 
 	// If we've found an object, no need keep track of it. We'll walk into the member objects instead.
 	if inferredType == "OBJECT" {
-		return
+		return ""
 	}
 
-	field := Field{
-		Node:         node,
-		Path:         EscapePath(path),
-		InferredType: inferredType,
-	}
-
-	if _, ok := t.Fields[path]; !ok {
-		t.Fields[path] = field
-		return
-	}
-	existingField := t.Fields[path]
+	field, ok := t.Fields[path]
 	// If we couldn't get a type example yet, we'll update.
-	if existingField.InferredType == "VARCHAR" {
-		t.Fields[path] = field
+	if !ok || field.InferredType == "VARCHAR" {
+		field.Node = node
+		field.Path = EscapePath(path)
+		field.InferredType = inferredType
+	}
+	if field.Stats == nil {
+		field.Stats = newFieldStats()
 	}
+	field.Stats.observe(c)
+	t.Fields[path] = field
+	return path
 }
 
 var arrayAtLineStart = regexp.MustCompile(`^[[0-9]*].`)
@@ -170,7 +261,7 @@ func EscapePath(s string) string {
 }
 
 // NormaliseKey takes a key and attempts to normalise it to a Snowflake-friendly format.
-// It will:
+// It is the default [NamingConvention], and will:
 //   - Convert from camelCase to SCREAMING_SNAKE_CASE
 //   - Cast to uppercase
 //   - Remove any non-underscore/non-alphanumeric characters
@@ -179,13 +270,7 @@ func EscapePath(s string) string {
 //   - Replace any double underscores with single underscores
 //   - Replace any dots with double underscores
 func NormaliseKey(s string) string {
-	s = camelCase.ReplaceAllString(s, `$1 $2 $3`)
-	s = strings.ToUpper(s)
-	s = strings.Join(validCharacters.FindAllString(s, -1), " ")
-	s = strings.Join(strings.Fields(s), "_")
-	s = strings.Trim(s, ` `)
-	s = strings.ReplaceAll(s, `.`, `__`)
-	return s
+	return NamingConvention{}.Name(s)
 }
 
 // CleanTableName derives a table name from a file name in a Snowflake-friendly format.
@@ -196,3 +281,9 @@ func CleanTableName(path string) string {
 	tableName = strings.Join(validCharacters.FindAllString(tableName, -1), "")
 	return tableName
 }
+
+// CleanSourceName derives a source name from the path of a directory in a Snowflake-friendly format.
+// Nested directories are joined by underscores.
+func CleanSourceName(dir string) string {
+	return CleanTableName(strings.ReplaceAll(filepath.ToSlash(dir), "/", "_"))
+}
@@ -0,0 +1,267 @@
+package templater
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A PIICategory classifies the personally identifiable information held by a [Field].
+// The zero value means no PII was detected.
+type PIICategory string
+
+const (
+	PIIEmail      PIICategory = "email"
+	PIIPhone      PIICategory = "phone"
+	PIICreditCard PIICategory = "credit_card"
+	PIIIPAddress  PIICategory = "ip_address"
+	PIINationalID PIICategory = "national_id"
+	PIIName       PIICategory = "name"
+)
+
+// piiValueThreshold is the fraction of sampled values that must look like a [PIICategory] for a field to be classified by its values.
+const piiValueThreshold = 0.9
+
+// piiValuePatterns recognise the values of each [PIICategory] that can be detected from its values alone.
+var piiValuePatterns = []struct {
+	Category PIICategory
+	Matches  func(string) bool
+}{
+	{Category: PIIEmail, Matches: emailPattern.MatchString},
+	{Category: PIICreditCard, Matches: isCreditCardNumber},
+	{Category: PIIIPAddress, Matches: func(s string) bool { return net.ParseIP(s) != nil }},
+	{Category: PIINationalID, Matches: nationalIDPattern.MatchString},
+	{Category: PIIPhone, Matches: phonePattern.MatchString},
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// nationalIDPattern matches the format of a US Social Security Number.
+var nationalIDPattern = regexp.MustCompile(`^\d{3}-\d{2}-\d{4}$`)
+
+// phonePattern matches numbers with a leading + or separators, so as not to confuse phone numbers with plain integers.
+var phonePattern = regexp.MustCompile(`^(\+\d[\d ().-]{6,18}\d|\(?\d{2,4}\)?[ .-]\d{3,4}[ .-]\d{3,4})$`)
+
+var cardSeparators = strings.NewReplacer(" ", "", "-", "")
+
+// isCreditCardNumber reports whether the string is a 13 to 19 digit number that passes the Luhn checksum.
+//
+// Reference: https://en.wikipedia.org/wiki/Luhn_algorithm.
+func isCreditCardNumber(s string) bool {
+	s = cardSeparators.Replace(s)
+	if len(s) < 13 || len(s) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range s {
+		digit := s[len(s)-1-i]
+		if digit < '0' || digit > '9' {
+			return false
+		}
+		d := int(digit - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// piiNamePatterns recognise the column names of each [PIICategory], matched against the underscore separated words of a normalised key.
+var piiNamePatterns = []struct {
+	Category PIICategory
+	Pattern  *regexp.Regexp
+}{
+	{Category: PIIEmail, Pattern: regexp.MustCompile(`(^|_)E_?MAIL(_|$)`)},
+	{Category: PIIPhone, Pattern: regexp.MustCompile(`(^|_)(PHONE|TELEPHONE|MOBILE|CELL|FAX)(_|$)`)},
+	{Category: PIICreditCard, Pattern: regexp.MustCompile(`(^|_)(CREDIT_CARD|CARD_(NUMBER|NUM|NO)|CC_(NUMBER|NUM|NO)|PAN)(_|$)`)},
+	{Category: PIIIPAddress, Pattern: regexp.MustCompile(`(^|_)IP(_ADDRESS)?(_|$)`)},
+	{Category: PIINationalID, Pattern: regexp.MustCompile(`(^|_)(SSN|TFN|NINO|PASSPORT|NATIONAL_ID|SOCIAL_SECURITY|TAX_FILE|DRIVERS?_LICEN[CS]E)(_|$)`)},
+	{Category: PIIName, Pattern: regexp.MustCompile(`(^|_)(FIRST_?NAME|LAST_?NAME|FULL_?NAME|GIVEN_?NAME|MIDDLE_?NAME|SURNAME|FAMILY_?NAME)(_|$)`)},
+}
+
+// DetectPII classifies the [PIICategory] of each [Field] of the given [Table]s.
+// Fields are classified by their sampled values where possible, and otherwise by their names.
+func DetectPII(tables []*Table) {
+	for _, table := range tables {
+		for path, field := range table.Fields {
+			field.PII = classifyPII(field)
+			table.Fields[path] = field
+		}
+	}
+}
+
+// classifyPII returns the [PIICategory] of a [Field], if any.
+func classifyPII(field Field) PIICategory {
+	if field.Stats != nil {
+		if category := field.Stats.valuePII(); category != "" {
+			return category
+		}
+	}
+	node := NormaliseKey(field.Node)
+	for _, p := range piiNamePatterns {
+		if p.Pattern.MatchString(node) {
+			return p.Category
+		}
+	}
+	return ""
+}
+
+// valuePII returns the [PIICategory] that most of the sampled values of the [FieldStats] look like, if any.
+func (s *FieldStats) valuePII() PIICategory {
+	if s.summary != nil {
+		return s.summary.PII
+	}
+	values, _ := s.sampleValues()
+	for _, p := range piiValuePatterns {
+		if matchesMost(values, p.Matches) {
+			return p.Category
+		}
+	}
+	return ""
+}
+
+// matchesMost reports whether at least [piiValueThreshold] of the values match.
+func matchesMost(values []string, matches func(string) bool) bool {
+	if len(values) == 0 {
+		return false
+	}
+	matched := 0
+	for _, value := range values {
+		if matches(value) {
+			matched++
+		}
+	}
+	return float64(matched)/float64(len(values)) >= piiValueThreshold
+}
+
+// A MaskingMode determines how columns classified as PII are masked.
+type MaskingMode string
+
+const (
+	// MaskNone leaves PII columns unmasked, only tagging them.
+	MaskNone MaskingMode = "none"
+	// MaskMacro wraps each PII column in a call to the mask_pii DBT macro.
+	MaskMacro MaskingMode = "macro"
+	// MaskPolicy creates a Snowflake masking policy for each kind of PII, applied to the columns after the model is built.
+	MaskPolicy MaskingMode = "policy"
+)
+
+// ParseMaskingMode parses the name of a [MaskingMode].
+func ParseMaskingMode(s string) (MaskingMode, error) {
+	switch mode := MaskingMode(s); mode {
+	case MaskNone, MaskMacro, MaskPolicy:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown masking mode %q, want one of none, macro or policy", s)
+}
+
+// enabled reports whether the [MaskingMode] masks any columns at all.
+func (m MaskingMode) enabled() bool {
+	return m != "" && m != MaskNone
+}
+
+// detectsPII reports whether the [Options] detect PII, which masking implies.
+func (o Options) detectsPII() bool {
+	return o.PII || o.Masking.enabled()
+}
+
+// maskingMacro is the name of the DBT macro that PII columns are wrapped in under [MaskMacro].
+const maskingMacro = "mask_pii"
+
+// maskingMacroSQL is a default implementation of the [maskingMacro], to be adapted to the project's needs.
+const maskingMacroSQL = `{% macro mask_pii(column, category) %}
+  {#- Replace with the masking appropriate for each category of PII. -#}
+  SHA2({{ column }}::STRING)
+{% endmacro %}
+`
+
+// piiReaderRole is the Snowflake role allowed to see unmasked PII under [MaskPolicy].
+const piiReaderRole = "PII_READER"
+
+// GenerateMaskedColumnSQL wraps the SQL of a column in a call to the masking macro for its [PIICategory].
+func GenerateMaskedColumnSQL(column string, category PIICategory) string {
+	column = strings.ReplaceAll(column, `'`, `\'`)
+	return fmt.Sprintf(`{{ %s('%s', '%s') }}`, maskingMacro, column, category)
+}
+
+// maskingPolicyName names the masking policy for a [PIICategory] and the Snowflake type it applies to.
+func maskingPolicyName(category PIICategory, inferredType string) string {
+	return strings.ToUpper(fmt.Sprintf("PII_%s_%s", category, inferredType))
+}
+
+// maskingHookRelation is the kind of relation a masking policy hook alters, which depends on how the model is materialized.
+// Models are views unless configured otherwise, and Snowflake only alters the columns of a view with ALTER VIEW.
+const maskingHookRelation = `{{ 'VIEW' if model.config.materialized == 'view' else 'TABLE' }}`
+
+// GenerateMaskingPolicyHooksSQL generates a config block with post hooks that apply a masking policy to each PII column of the [Table].
+// It returns an empty string if the table has no PII columns.
+//
+// Reference: https://docs.getdbt.com/reference/resource-configs/pre-hook-post-hook.
+// Reference: https://docs.snowflake.com/en/sql-reference/sql/alter-view.html.
+func GenerateMaskingPolicyHooksSQL(table Table, opts ...ModelOption) string {
+	config := newModelConfig(opts...)
+	hooks := []string{}
+	for _, field := range table.Fields {
+		if field.PII == "" {
+			continue
+		}
+		column := strings.ReplaceAll(config.columnSQL(field.Node), `"`, `\"`)
+		hooks = append(hooks, fmt.Sprintf(`"ALTER %s {{ this }} MODIFY COLUMN %s SET MASKING POLICY %s"`,
+			maskingHookRelation, column, maskingPolicyName(field.PII, field.InferredType)))
+	}
+	if len(hooks) == 0 {
+		return ""
+	}
+	sort.Strings(hooks)
+	return fmt.Sprintf("{{ config(post_hook=[%s]) }}", strings.Join(hooks, ", "))
+}
+
+// GenerateMaskingPoliciesDDL generates the Snowflake DDL for the masking policies referenced by the PII columns of the [Table]s.
+// Only the [piiReaderRole] may see the unmasked values.
+//
+// Reference: https://docs.snowflake.com/en/sql-reference/sql/create-masking-policy.html.
+func GenerateMaskingPoliciesDDL(tables []*Table) string {
+	policies := make(map[string]string)
+	for _, table := range tables {
+		for _, field := range table.Fields {
+			if field.PII == "" {
+				continue
+			}
+			masked := "NULL"
+			if field.InferredType == "STRING" || field.InferredType == "VARCHAR" {
+				masked = "'***MASKED***'"
+			}
+			name := maskingPolicyName(field.PII, field.InferredType)
+			policies[name] = fmt.Sprintf(
+				"CREATE MASKING POLICY IF NOT EXISTS %s AS (val %s) RETURNS %s ->\n  CASE WHEN CURRENT_ROLE() IN ('%s') THEN val ELSE %s END;\n",
+				name, field.InferredType, field.InferredType, piiReaderRole, masked)
+		}
+	}
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ddl := []string{}
+	for _, name := range names {
+		ddl = append(ddl, policies[name])
+	}
+	return strings.Join(ddl, "\n")
+}
+
+// writeMasking writes the supporting files required by the [MaskingMode] to the [Sink].
+func writeMasking(sink Sink, mode MaskingMode, tables []*Table) error {
+	switch mode {
+	case MaskMacro:
+		return sink.WriteFile("macros/mask_pii.sql", []byte(maskingMacroSQL))
+	case MaskPolicy:
+		return sink.WriteFile("ddl/masking_policies.sql", []byte(GenerateMaskingPoliciesDDL(tables)))
+	}
+	return nil
+}
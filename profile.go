@@ -0,0 +1,269 @@
+package templater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// profileTopValues is the number of most common values listed for each field of a [Profile].
+const profileTopValues = 5
+
+// A Profile summarises the values observed in each [Table] during inference.
+type Profile struct {
+	Tables []TableProfile `json:"tables"`
+}
+
+// A TableProfile summarises the values observed in a single [Table].
+//
+// Sampling: Describes the [Sampling] used to choose the rows observed, if not every row was.
+//
+// TotalRows: The number of rows in the table when only a sample was observed, if known.
+type TableProfile struct {
+	Name      string         `json:"name"`
+	Rows      int            `json:"rows"`
+	Sampling  string         `json:"sampling,omitempty"`
+	TotalRows int            `json:"total_rows,omitempty"`
+	Fields    []FieldProfile `json:"fields"`
+}
+
+// A FieldProfile summarises the values observed for a single [Field].
+//
+// Distinct: The number of distinct non-null values, which is an estimate when DistinctExact is false.
+//
+// Min, Max and Mean: Describe numeric values. For string values Min and Max are compared lexically, and Mean is omitted.
+//
+// Lengths: Describes the lengths of string values.
+//
+// TypeConflicts: The number of non-null values that were not of the inferred type.
+//
+// PII: The [PIICategory] of a field detected to hold personally identifiable information.
+// The values of such fields are left out, so they have no Min, Max or TopValues.
+type FieldProfile struct {
+	Path          string         `json:"path"`
+	Node          string         `json:"node"`
+	InferredType  string         `json:"inferred_type"`
+	Nulls         int            `json:"nulls"`
+	NullPercent   float64        `json:"null_percent"`
+	Distinct      int            `json:"distinct"`
+	DistinctExact bool           `json:"distinct_exact"`
+	Min           any            `json:"min,omitempty"`
+	Max           any            `json:"max,omitempty"`
+	Mean          *float64       `json:"mean,omitempty"`
+	Lengths       *LengthProfile `json:"lengths,omitempty"`
+	TopValues     []ValueCount   `json:"top_values"`
+	TypeConflicts int            `json:"type_conflicts"`
+	PII           PIICategory    `json:"pii,omitempty"`
+}
+
+// A LengthProfile describes the distribution of the lengths of string values.
+//
+// Buckets: The number of values of each length, with lengths rounded up to the nearest power of two.
+type LengthProfile struct {
+	Min     int            `json:"min"`
+	Max     int            `json:"max"`
+	Mean    float64        `json:"mean"`
+	Buckets []LengthBucket `json:"buckets"`
+}
+
+// A LengthBucket counts the string values no longer than MaxLength, and longer than the previous bucket.
+type LengthBucket struct {
+	MaxLength int `json:"max_length"`
+	Count     int `json:"count"`
+}
+
+// NewProfile summarises the values observed in the given [Table]s.
+// Tables and fields are sorted so that the profile is stable from run to run.
+func NewProfile(tables []*Table) Profile {
+	profile := Profile{Tables: []TableProfile{}}
+	for _, table := range tables {
+		tableProfile := TableProfile{
+			Name:   table.Name,
+			Rows:   table.Rows,
+			Fields: []FieldProfile{},
+		}
+		if table.Sampling.enabled() {
+			tableProfile.Sampling = table.Sampling.String()
+			tableProfile.TotalRows = table.TotalRows
+		}
+		for _, field := range table.Fields {
+			tableProfile.Fields = append(tableProfile.Fields, profileField(field, table.Rows))
+		}
+		sort.Slice(tableProfile.Fields, func(i, j int) bool {
+			return tableProfile.Fields[i].Node < tableProfile.Fields[j].Node
+		})
+		profile.Tables = append(profile.Tables, tableProfile)
+	}
+	sort.Slice(profile.Tables, func(i, j int) bool {
+		return profile.Tables[i].Name < profile.Tables[j].Name
+	})
+	return profile
+}
+
+// profileField summarises the values observed for a single [Field] of a table with the given number of rows.
+func profileField(field Field, rows int) FieldProfile {
+	p := FieldProfile{
+		Path:         field.Path,
+		Node:         field.Node,
+		InferredType: field.InferredType,
+		PII:          field.PII,
+		Nulls:        rows,
+		NullPercent:  100,
+		TopValues:    []ValueCount{},
+	}
+	stats := field.Stats
+	if stats == nil {
+		return p
+	}
+	p.Nulls = stats.Nulls(rows)
+	if rows > 0 {
+		p.NullPercent = 100 * float64(p.Nulls) / float64(rows)
+	}
+	p.Distinct, p.DistinctExact = stats.Distinct()
+	p.TopValues = stats.TopValues(profileTopValues)
+	p.TypeConflicts = stats.TypeConflicts(field.InferredType)
+	if stats.numbers > 0 {
+		mean := stats.sum / float64(stats.numbers)
+		p.Min, p.Max, p.Mean = stats.min, stats.max, &mean
+	}
+	if stats.strings > 0 {
+		if stats.numbers == 0 {
+			p.Min, p.Max = stats.minString, stats.maxString
+		}
+		p.Lengths = &LengthProfile{
+			Min:  stats.minLength,
+			Max:  stats.maxLength,
+			Mean: float64(stats.lengthSum) / float64(stats.strings),
+		}
+		buckets := maps.Keys(stats.lengths)
+		sort.Ints(buckets)
+		for _, bucket := range buckets {
+			p.Lengths.Buckets = append(p.Lengths.Buckets, LengthBucket{MaxLength: bucket, Count: stats.lengths[bucket]})
+		}
+	}
+	if field.PII != "" || stats.redacted() {
+		p.Min, p.Max, p.TopValues = nil, nil, []ValueCount{}
+	}
+	return p
+}
+
+// WriteJSON writes the [Profile] to the io.Writer as indented JSON.
+func (p Profile) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WriteMarkdown writes the [Profile] to the io.Writer as a human readable Markdown report,
+// with a table of fields for each [Table].
+func (p Profile) WriteMarkdown(w io.Writer) error {
+	report := "# Data Profile\n"
+	for _, table := range p.Tables {
+		report += fmt.Sprintf("\n## %s\n\n%s\n\n", table.Name, markdownRows(table))
+		report += "| Column | Type | Null % | Distinct | Min | Max | Mean | Length | Top Values | Type Conflicts |\n"
+		report += "|---|---|---|---|---|---|---|---|---|---|\n"
+		for _, field := range table.Fields {
+			min, max, topValues := markdownCell(field.Min), markdownCell(field.Max), markdownTopValues(field.TopValues)
+			if field.PII != "" {
+				min, max, topValues = markdownMasked, markdownMasked, markdownMasked
+			}
+			report += fmt.Sprintf("| %s | %s | %.1f | %s | %s | %s | %s | %s | %s | %d |\n",
+				field.Node,
+				field.InferredType,
+				field.NullPercent,
+				markdownDistinct(field),
+				min,
+				max,
+				markdownMean(field.Mean),
+				markdownLengths(field.Lengths),
+				topValues,
+				field.TypeConflicts,
+			)
+		}
+	}
+	_, err := io.WriteString(w, report)
+	return err
+}
+
+// markdownMasked stands in for the values of a PII field in a Markdown report.
+const markdownMasked = "*masked*"
+
+// markdownRows formats the number of rows of a [TableProfile], along with how they were sampled.
+func markdownRows(table TableProfile) string {
+	switch {
+	case table.Sampling == "":
+		return fmt.Sprintf("%d rows", table.Rows)
+	case table.TotalRows > 0:
+		return fmt.Sprintf("%d of %d rows sampled (%s)", table.Rows, table.TotalRows, table.Sampling)
+	}
+	return fmt.Sprintf("%d rows sampled (%s)", table.Rows, table.Sampling)
+}
+
+// markdownDistinct formats the distinct count of a [FieldProfile], marking counts that are only an estimate.
+func markdownDistinct(field FieldProfile) string {
+	if !field.DistinctExact {
+		return fmt.Sprintf("~%d", field.Distinct)
+	}
+	return fmt.Sprint(field.Distinct)
+}
+
+// markdownMean formats an optional mean.
+func markdownMean(mean *float64) string {
+	if mean == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g", *mean)
+}
+
+// markdownLengths formats a [LengthProfile] as its range and mean.
+func markdownLengths(lengths *LengthProfile) string {
+	if lengths == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d (mean %.1f)", lengths.Min, lengths.Max, lengths.Mean)
+}
+
+// markdownTopValues formats each [ValueCount] as the value followed by its count.
+func markdownTopValues(values []ValueCount) string {
+	formatted := []string{}
+	for _, value := range values {
+		formatted = append(formatted, fmt.Sprintf("%s (%d)", markdownCell(value.Value), value.Count))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// markdownCell formats a value so that it can't break out of a Markdown table cell.
+func markdownCell(v any) string {
+	if v == nil {
+		return ""
+	}
+	s := fmt.Sprint(v)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	s = strings.ReplaceAll(s, "\n", " ")
+	return s
+}
+
+// writeProfile writes the [Profile] of the [Table]s to the [Sink] as profile.json and profile.md.
+func writeProfile(sink Sink, tables []*Table) error {
+	profile := NewProfile(tables)
+	buf := new(bytes.Buffer)
+	err := profile.WriteJSON(buf)
+	if err != nil {
+		return err
+	}
+	err = sink.WriteFile("profile.json", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	err = profile.WriteMarkdown(buf)
+	if err != nil {
+		return err
+	}
+	return sink.WriteFile("profile.md", buf.Bytes())
+}
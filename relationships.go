@@ -0,0 +1,205 @@
+package templater
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A Key identifies a column of a table by the table name and the target column name.
+type Key struct {
+	Table  string
+	Column string
+}
+
+// String formats the [Key] as TABLE.COLUMN.
+func (k Key) String() string {
+	return fmt.Sprintf("%s.%s", k.Table, k.Column)
+}
+
+// A Relationship is an inferred foreign key, from a column of one table to the primary key of another.
+type Relationship struct {
+	From Key
+	To   Key
+}
+
+// An EntityGraph holds the primary keys and relationships inferred across a set of [Table]s.
+type EntityGraph struct {
+	PrimaryKeys   []Key
+	Relationships []Relationship
+}
+
+// InferEntityGraph detects candidate primary and foreign keys across the given [Table]s.
+//
+// A primary key candidate is an INTEGER or STRING column whose observed values are all non-null and unique.
+// Where a table has more than one candidate, names like ID and TABLE_ID are preferred.
+//
+// A foreign key is a column whose observed values are all present in the primary key of another table,
+// and whose name refers to that primary key, such as ORDER.CUSTOMER_ID referring to CUSTOMER.ID.
+// Names are compared as normalised by [NormaliseKey], whatever the [NamingConvention] of each table,
+// and tables are referred to by their name within their source.
+func InferEntityGraph(tables []*Table) EntityGraph {
+	graph := EntityGraph{}
+	primaryKeys := make(map[string]Field)
+	for _, table := range tables {
+		field, ok := primaryKey(table)
+		if !ok {
+			continue
+		}
+		primaryKeys[table.Name] = field
+		graph.PrimaryKeys = append(graph.PrimaryKeys, Key{Table: table.Name, Column: table.Naming.Name(field.Node)})
+	}
+
+	for _, table := range tables {
+		for _, field := range table.Fields {
+			if field.Stats == nil || field.Stats.NonNull == 0 {
+				continue
+			}
+			column := NormaliseKey(field.Node)
+			if pk, ok := primaryKeys[table.Name]; ok && NormaliseKey(pk.Node) == column {
+				continue
+			}
+			for _, target := range tables {
+				pk, ok := primaryKeys[target.Name]
+				if !ok || target.Name == table.Name {
+					continue
+				}
+				_, targetName := target.source()
+				if !refersTo(column, targetName, NormaliseKey(pk.Node)) || !field.Stats.subsetOf(pk.Stats) {
+					continue
+				}
+				graph.Relationships = append(graph.Relationships, Relationship{
+					From: Key{Table: table.Name, Column: table.Naming.Name(field.Node)},
+					To:   Key{Table: target.Name, Column: target.Naming.Name(pk.Node)},
+				})
+			}
+		}
+	}
+
+	sort.Slice(graph.PrimaryKeys, func(i, j int) bool {
+		return graph.PrimaryKeys[i].String() < graph.PrimaryKeys[j].String()
+	})
+	sort.Slice(graph.Relationships, func(i, j int) bool {
+		if graph.Relationships[i].From != graph.Relationships[j].From {
+			return graph.Relationships[i].From.String() < graph.Relationships[j].From.String()
+		}
+		return graph.Relationships[i].To.String() < graph.Relationships[j].To.String()
+	})
+	return graph
+}
+
+// primaryKey returns the best primary key candidate of a [Table], if it has one.
+func primaryKey(table *Table) (Field, bool) {
+	var best Field
+	bestScore := -1
+	for _, field := range table.Fields {
+		if !isKeyCandidate(field, table.Rows) {
+			continue
+		}
+		column := NormaliseKey(field.Node)
+		_, name := table.source()
+		score := primaryKeyScore(name, column)
+		if score > bestScore || (score == bestScore && column < NormaliseKey(best.Node)) {
+			best = field
+			bestScore = score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// isKeyCandidate reports whether every observed value of the [Field] was non-null and unique.
+func isKeyCandidate(field Field, rows int) bool {
+	if field.InferredType != "INTEGER" && field.InferredType != "STRING" {
+		return false
+	}
+	if field.Stats == nil || rows == 0 || field.Stats.NonNull != rows {
+		return false
+	}
+	distinct, exact := field.Stats.Distinct()
+	return exact && distinct == rows
+}
+
+// primaryKeyScore ranks how strongly a column name suggests it is the primary key of its table.
+func primaryKeyScore(table, column string) int {
+	switch {
+	case column == "ID":
+		return 3
+	case refersTo(column, table, "ID"):
+		return 2
+	case strings.HasSuffix(column, "_ID"):
+		return 1
+	}
+	return 0
+}
+
+// refersTo reports whether a column name refers to the primary key column of the given table.
+// For example CUSTOMER_ID and CUSTOMERS_ID both refer to CUSTOMERS.ID, and CUSTOMER_KEY refers to CUSTOMER.CUSTOMER_KEY.
+func refersTo(column, table, primaryKey string) bool {
+	if column == primaryKey && primaryKey != "ID" {
+		return true
+	}
+	for _, name := range tableNameForms(table) {
+		if column == fmt.Sprintf("%s_%s", name, primaryKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableNameForms returns the name of a table along with its likely singular forms.
+func tableNameForms(table string) []string {
+	forms := []string{table}
+	if strings.HasSuffix(table, "IES") {
+		forms = append(forms, strings.TrimSuffix(table, "IES")+"Y")
+	}
+	if strings.HasSuffix(table, "ES") {
+		forms = append(forms, strings.TrimSuffix(table, "ES"))
+	}
+	if strings.HasSuffix(table, "S") {
+		forms = append(forms, strings.TrimSuffix(table, "S"))
+	}
+	return forms
+}
+
+// relationshipsFrom returns the [Relationship]s whose foreign key is the given column.
+func relationshipsFrom(relationships []Relationship, from Key) []Relationship {
+	found := []Relationship{}
+	for _, relationship := range relationships {
+		if relationship.From == from {
+			found = append(found, relationship)
+		}
+	}
+	return found
+}
+
+// WriteReport writes a short Markdown summary of the [EntityGraph] to the io.Writer.
+func (g EntityGraph) WriteReport(w io.Writer) error {
+	report := "# Entity Graph\n\n## Primary Keys\n\n"
+	if len(g.PrimaryKeys) == 0 {
+		report += "None found.\n"
+	}
+	for _, key := range g.PrimaryKeys {
+		report += fmt.Sprintf("- %s\n", key)
+	}
+	report += "\n## Relationships\n\n"
+	if len(g.Relationships) == 0 {
+		report += "None found.\n"
+	}
+	for _, relationship := range g.Relationships {
+		report += fmt.Sprintf("- %s -> %s\n", relationship.From, relationship.To)
+	}
+	_, err := io.WriteString(w, report)
+	return err
+}
+
+// writeEntityGraph writes the report of the [EntityGraph] to the [Sink].
+func writeEntityGraph(sink Sink, g EntityGraph) error {
+	buf := new(bytes.Buffer)
+	err := g.WriteReport(buf)
+	if err != nil {
+		return err
+	}
+	return sink.WriteFile("entity_graph.md", buf.Bytes())
+}
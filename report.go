@@ -0,0 +1,230 @@
+package templater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"cuelang.org/go/cue"
+)
+
+// A Report is the machine readable record of a run, for orchestration that needs more than an exit code.
+//
+// Status: Either "ok" or "failed".
+//
+// Files: Each input file, along with the [Table] inferred from it.
+//
+// Warnings: Anything about the run worth a reviewer's attention that didn't stop it, such as renamed fields.
+//
+// Errors: Why the run failed, attributed to the input file that caused it where possible.
+//
+// Diagnostics: Everything that degraded the inference without failing it, or that failed it at the chosen [Severity].
+//
+// Output: Where the project was written: a directory, an archive or stdout.
+//
+// Written: The slash separated paths of the files written, within the Output.
+//
+// Lockfile: The path the [Lockfile] was written to.
+type Report struct {
+	Status      string        `json:"status"`
+	Files       []FileReport  `json:"files"`
+	Warnings    []Warning     `json:"warnings"`
+	Errors      []ReportError `json:"errors"`
+	Diagnostics []Diagnostic  `json:"diagnostics"`
+	Output      string        `json:"output,omitempty"`
+	Written     []string      `json:"written"`
+	Lockfile    string        `json:"lockfile,omitempty"`
+}
+
+// A FileReport records an input file and the [Table] inferred from it.
+//
+// UnpackPaths: The paths unpacked from JSON, out of those given, that were columns of the table.
+type FileReport struct {
+	File        string        `json:"file"`
+	Table       string        `json:"table"`
+	Rows        int           `json:"rows"`
+	Sampling    string        `json:"sampling,omitempty"`
+	TotalRows   int           `json:"total_rows,omitempty"`
+	UnpackPaths []string      `json:"unpack_paths"`
+	Fields      []FieldReport `json:"fields"`
+}
+
+// A FieldReport records the type inferred for a [Field].
+//
+// Confidence: The fraction of the non-null values observed that were of the inferred type, or zero where every value was null.
+type FieldReport struct {
+	Path         string  `json:"path"`
+	Node         string  `json:"node"`
+	InferredType string  `json:"inferred_type"`
+	Confidence   float64 `json:"confidence"`
+}
+
+// A WarningKind classifies a [Warning].
+type WarningKind string
+
+const (
+	// WarningCollision is a table or field renamed because its name collided with another.
+	WarningCollision WarningKind = "collision"
+	// WarningTypeConflict is a field with values that are not of its inferred type.
+	WarningTypeConflict WarningKind = "type_conflict"
+)
+
+// A Warning is something about a run worth a reviewer's attention that didn't stop it.
+type Warning struct {
+	Kind    WarningKind `json:"kind"`
+	File    string      `json:"file,omitempty"`
+	Table   string      `json:"table,omitempty"`
+	Path    string      `json:"path,omitempty"`
+	Message string      `json:"message"`
+}
+
+// A ReportError is an error that failed a run, along with the input file that caused it, if known.
+type ReportError struct {
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// NewReport records the [Result] of a successful run, given the paths that were to be unpacked from JSON.
+// Files, fields and warnings are sorted so that the report is stable from run to run.
+func NewReport(result *Result, unpackPaths []string) Report {
+	report := Report{
+		Status:      "ok",
+		Files:       []FileReport{},
+		Warnings:    []Warning{},
+		Errors:      []ReportError{},
+		Diagnostics: result.Diagnostics,
+		Written:     result.Artifacts.Paths(),
+	}
+	files := make(map[string]string)
+	for _, table := range result.Tables {
+		files[table.Name] = table.File
+		report.Files = append(report.Files, reportFile(table, unpackPaths))
+		for _, field := range table.Fields {
+			if field.Stats == nil {
+				continue
+			}
+			conflicts := field.Stats.TypeConflicts(field.InferredType)
+			if conflicts == 0 {
+				continue
+			}
+			report.Warnings = append(report.Warnings, Warning{
+				Kind:    WarningTypeConflict,
+				File:    table.File,
+				Table:   table.Name,
+				Path:    field.Path,
+				Message: fmt.Sprintf("%d of %d values are not %s", conflicts, field.Stats.NonNull, field.InferredType),
+			})
+		}
+	}
+	for _, rename := range result.Renames {
+		warning := Warning{Kind: WarningCollision, Table: rename.Table, Path: rename.Path, Message: rename.String()}
+		if rename.Table == "" {
+			warning.File = rename.Path
+			warning.Path = ""
+		} else {
+			warning.File = files[rename.Table]
+		}
+		report.Warnings = append(report.Warnings, warning)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].File < report.Files[j].File
+	})
+	sort.SliceStable(report.Warnings, func(i, j int) bool {
+		a, b := report.Warnings[i], report.Warnings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Path < b.Path
+	})
+	return report
+}
+
+// reportFile records the input file of a [Table], and the fields inferred from it.
+func reportFile(table *Table, unpackPaths []string) FileReport {
+	file := FileReport{
+		File:        table.File,
+		Table:       table.Name,
+		Rows:        table.Rows,
+		UnpackPaths: []string{},
+		Fields:      []FieldReport{},
+	}
+	if table.Sampling.enabled() {
+		file.Sampling = table.Sampling.String()
+		file.TotalRows = table.TotalRows
+	}
+	for _, path := range unpackPaths {
+		if _, ok := table.columns[columnOf(cue.ParsePath(path))]; ok {
+			file.UnpackPaths = append(file.UnpackPaths, path)
+		}
+	}
+	for _, field := range table.Fields {
+		report := FieldReport{Path: field.Path, Node: field.Node, InferredType: field.InferredType}
+		if field.Stats != nil && field.Stats.NonNull > 0 {
+			matching := field.Stats.NonNull - field.Stats.TypeConflicts(field.InferredType)
+			report.Confidence = float64(matching) / float64(field.Stats.NonNull)
+		}
+		file.Fields = append(file.Fields, report)
+	}
+	sort.Slice(file.Fields, func(i, j int) bool {
+		return file.Fields[i].Node < file.Fields[j].Node
+	})
+	return file
+}
+
+// FailedReport records a run that failed with the given error.
+// Each [TableError] is attributed to the file of its table.
+func FailedReport(err error) Report {
+	report := Report{
+		Status:      "failed",
+		Files:       []FileReport{},
+		Warnings:    []Warning{},
+		Errors:      []ReportError{},
+		Diagnostics: []Diagnostic{},
+		Written:     []string{},
+	}
+	var diagnosticsErr *DiagnosticsError
+	if errors.As(err, &diagnosticsErr) {
+		report.Diagnostics = diagnosticsErr.Diagnostics
+	}
+	errs := []error{err}
+	var failed TableErrors
+	if errors.As(err, &failed) {
+		errs = failed
+	}
+	for _, err := range errs {
+		var tableErr *TableError
+		if errors.As(err, &tableErr) {
+			report.Errors = append(report.Errors, ReportError{File: tableErr.File, Message: tableErr.Err.Error()})
+			continue
+		}
+		report.Errors = append(report.Errors, ReportError{Message: err.Error()})
+	}
+	return report
+}
+
+// writeReport writes the [Report] as JSON to the file at the given path, or to stdout if the path is "-".
+func writeReport(path string, report Report) error {
+	if path == "-" {
+		return report.WriteJSON(os.Stdout)
+	}
+	buf := new(bytes.Buffer)
+	err := report.WriteJSON(buf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// WriteJSON writes the [Report] to the io.Writer as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
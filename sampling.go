@@ -0,0 +1,151 @@
+package templater
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// A SamplingStrategy determines which rows of a table are read during inference.
+type SamplingStrategy string
+
+const (
+	// SampleAll reads every row. It is the default.
+	SampleAll SamplingStrategy = "all"
+	// SampleFirst reads the first rows of the table, stopping early.
+	SampleFirst SamplingStrategy = "first"
+	// SampleReservoir reads a uniformly random sample of rows from across the whole table.
+	SampleReservoir SamplingStrategy = "reservoir"
+	// SamplePercent reads each row with a fixed probability.
+	SamplePercent SamplingStrategy = "percent"
+)
+
+// ParseSamplingStrategy parses the name of a [SamplingStrategy].
+func ParseSamplingStrategy(s string) (SamplingStrategy, error) {
+	switch strategy := SamplingStrategy(s); strategy {
+	case SampleAll, SampleFirst, SampleReservoir, SamplePercent:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown sampling strategy %q, want one of all, first, reservoir or percent", s)
+}
+
+// Sampling configures which rows of each table are read during inference.
+// The zero value reads every row.
+//
+// Rows: The number of rows read by [SampleFirst] and [SampleReservoir].
+//
+// Percent: The percentage of rows read by [SamplePercent].
+//
+// Seed: Seeds the random choices of [SampleReservoir] and [SamplePercent], so that the same rows are sampled from run to run.
+type Sampling struct {
+	Strategy SamplingStrategy
+	Rows     int
+	Percent  float64
+	Seed     int64
+}
+
+// enabled reports whether the [Sampling] reads fewer than every row.
+func (s Sampling) enabled() bool {
+	return s.Strategy != "" && s.Strategy != SampleAll
+}
+
+// Validate reports whether the [Sampling] has what its strategy needs.
+func (s Sampling) Validate() error {
+	switch s.Strategy {
+	case SampleFirst, SampleReservoir:
+		if s.Rows <= 0 {
+			return fmt.Errorf("%s sampling needs a positive number of rows, got %d", s.Strategy, s.Rows)
+		}
+	case SamplePercent:
+		if s.Percent <= 0 || s.Percent > 100 {
+			return fmt.Errorf("percent sampling needs a percentage above 0 and at most 100, got %g", s.Percent)
+		}
+	}
+	return nil
+}
+
+// String describes the [Sampling] for reviewers of the generated project.
+func (s Sampling) String() string {
+	switch s.Strategy {
+	case SampleFirst:
+		return fmt.Sprintf("first %d rows", s.Rows)
+	case SampleReservoir:
+		return fmt.Sprintf("reservoir of %d rows, seed %d", s.Rows, s.Seed)
+	case SamplePercent:
+		return fmt.Sprintf("%g%% of rows, seed %d", s.Percent, s.Seed)
+	}
+	return "all rows"
+}
+
+// A sampledRecord is a CSV record kept in a reservoir, along with its position in the CSV and the line it starts on.
+type sampledRecord struct {
+	index  int
+	line   int
+	record []string
+}
+
+// sampleRecords streams through the records of a CSV after its header, calling fn with the header, each record chosen by the [Sampling],
+// and the line of the CSV the record starts on.
+// Records are passed to fn in the order they appear in the CSV, and are only valid until fn returns.
+// The choice of records is deterministic, so streaming through the same CSV again chooses the same records.
+//
+// It returns the header of the CSV and the number of records in it, and reports whether that number is known,
+// as it isn't when sampling stopped reading before the end of the CSV.
+func sampleRecords(reader *csv.Reader, sampling Sampling, fn func(header, record []string, line int) error) ([]string, int, bool, error) {
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, 0, false, errors.New("empty CSV")
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	header = append([]string{}, header...)
+	random := rand.New(rand.NewSource(sampling.Seed))
+	reservoir := []sampledRecord{}
+	total := 0
+	for ; ; total++ {
+		if sampling.Strategy == SampleFirst && total == sampling.Rows {
+			return header, total, false, nil
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		line, _ := reader.FieldPos(0)
+		switch sampling.Strategy {
+		case SampleReservoir:
+			if len(reservoir) < sampling.Rows {
+				reservoir = append(reservoir, sampledRecord{index: total, line: line, record: append([]string{}, record...)})
+				continue
+			}
+			if i := random.Intn(total + 1); i < sampling.Rows {
+				reservoir[i] = sampledRecord{index: total, line: line, record: append([]string{}, record...)}
+			}
+			continue
+		case SamplePercent:
+			if random.Float64()*100 >= sampling.Percent {
+				continue
+			}
+		}
+		err = fn(header, record, line)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+	sort.Slice(reservoir, func(i, j int) bool {
+		return reservoir[i].index < reservoir[j].index
+	})
+	for _, sampled := range reservoir {
+		err := fn(header, sampled.record, sampled.line)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+	return header, total, true, nil
+}
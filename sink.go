@@ -0,0 +1,463 @@
+package templater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Sink is where the files of a generated project are written, by their slash separated path within the output.
+type Sink interface {
+	WriteFile(path string, contents []byte) error
+}
+
+// An OutputFormat determines the [Sink] [Main] writes the generated project to.
+type OutputFormat string
+
+const (
+	// OutputDir writes each file to a directory. It is the default.
+	OutputDir OutputFormat = "dir"
+	// OutputZip writes the files to a zip archive.
+	OutputZip OutputFormat = "zip"
+	// OutputTar writes the files to a tar archive.
+	OutputTar OutputFormat = "tar"
+	// OutputStdout writes the files to stdout, one document after another.
+	OutputStdout OutputFormat = "stdout"
+)
+
+// ParseOutputFormat parses the name of an [OutputFormat].
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch format := OutputFormat(s); format {
+	case OutputDir, OutputZip, OutputTar, OutputStdout:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, want one of dir, zip, tar or stdout", s)
+}
+
+// defaultOutput returns where the [OutputFormat] writes to unless otherwise specified.
+func (f OutputFormat) defaultOutput() string {
+	switch f {
+	case OutputZip:
+		return "output.zip"
+	case OutputTar:
+		return "output.tar"
+	}
+	return "output"
+}
+
+// A pendingSink is a [Sink] whose files only take effect once it is committed, and are discarded if it is aborted.
+type pendingSink interface {
+	Sink
+	Commit() error
+	Abort() error
+}
+
+// openSink opens the [Sink] of the [OutputFormat] at the given path.
+// Nothing is written to the path until the sink is committed: archives are written beside it, and the files of a directory beside each file they replace,
+// so a failed run leaves the previous output untouched. The stale files of a directory are removed when it is committed.
+func openSink(format OutputFormat, path string, stale []string) (pendingSink, error) {
+	switch format {
+	case OutputStdout:
+		return streamingSink{NewStreamSink(os.Stdout)}, nil
+	case OutputZip, OutputTar:
+		return newArchiveSink(format, path)
+	}
+	return newAtomicDirSink(path, stale)
+}
+
+// writeOutput writes the [Result] to the [OutputFormat] at the given path, and its [Lockfile] to the lockfile path.
+// A lockfile inside the output directory is written along with the rest of the output, so the two never disagree.
+// When writing to a directory, the lockfile records the files written, and any files the previous lockfile recorded that are no longer generated are removed.
+func writeOutput(result *Result, format OutputFormat, output string, lockfile string) error {
+	locked := result.Lockfile
+	var stale []string
+	if format == OutputDir {
+		locked.Output, locked.Files = filepath.Clean(output), result.Artifacts.Paths()
+		stale = staleFiles(result.Artifacts, output, lockfile)
+	}
+	sink, err := openSink(format, output, stale)
+	if err != nil {
+		return err
+	}
+	err = result.Artifacts.CopyTo(sink)
+	rel, within := lockfileWithin(output, lockfile)
+	if err == nil && format == OutputDir && within {
+		buf := new(bytes.Buffer)
+		err = locked.Write(buf)
+		if err == nil {
+			err = sink.WriteFile(rel, buf.Bytes())
+		}
+	}
+	if err != nil {
+		sink.Abort()
+		return err
+	}
+	err = sink.Commit()
+	if err != nil {
+		return err
+	}
+	if format == OutputDir && within {
+		return nil
+	}
+	return writeLockfile(lockfile, locked)
+}
+
+// staleFiles returns the files that the previous run generated in the output directory that are no longer among the [Artifacts].
+func staleFiles(artifacts Artifacts, output string, lockfile string) []string {
+	stale := []string{}
+	for _, path := range generatedFiles(output, lockfile) {
+		if _, ok := artifacts[path]; !ok {
+			stale = append(stale, path)
+		}
+	}
+	return stale
+}
+
+// generatedFiles returns the slash separated paths of the files the previous run generated in the output directory, as recorded by its lockfile.
+// There are none without a lockfile, or where it records another output directory.
+func generatedFiles(output string, lockfile string) []string {
+	file, err := os.Open(lockfile)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	previous, err := ReadLockfile(file)
+	if err != nil || previous.Output != filepath.Clean(output) {
+		return nil
+	}
+	generated := []string{}
+	for _, path := range previous.Files {
+		if fs.ValidPath(path) {
+			generated = append(generated, path)
+		}
+	}
+	return generated
+}
+
+// lockfileWithin returns the slash separated path of the lockfile within the output directory, and reports whether it is within it.
+func lockfileWithin(output string, lockfile string) (string, bool) {
+	rel, err := filepath.Rel(output, lockfile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// A streamingSink is a [StreamSink] whose files take effect as soon as they are written, so can't be aborted.
+type streamingSink struct {
+	*StreamSink
+}
+
+func (streamingSink) Commit() error { return nil }
+func (streamingSink) Abort() error  { return nil }
+
+// An atomicDirSink writes each file to a temporary file beside the file it replaces,
+// renaming each temporary file over its target when committed, and removing the stale files generated by a previous run.
+// Only the files it writes or removes are touched, so anything else in the directory, such as a hand written dbt_project.yml, is left alone.
+type atomicDirSink struct {
+	root    string
+	stale   []string
+	pending []pendingFile
+}
+
+// A pendingFile is a temporary file written by an [atomicDirSink], waiting to be renamed over its target.
+type pendingFile struct {
+	temp   string
+	target string
+}
+
+// newAtomicDirSink creates the directory at root, if it doesn't already exist, along with the project directories within it.
+// The stale files are the slash separated paths within it to remove when committed.
+func newAtomicDirSink(root string, stale []string) (*atomicDirSink, error) {
+	root = filepath.Clean(root)
+	err := createProjectDirectories(root)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicDirSink{root: root, stale: stale}, nil
+}
+
+// WriteFile writes the contents to a temporary file in the directory of the file at path, creating any missing parent directories.
+func (d *atomicDirSink) WriteFile(path string, contents []byte) error {
+	target := filepath.Join(d.root, filepath.FromSlash(path))
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(contents)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	d.pending = append(d.pending, pendingFile{temp: file.Name(), target: target})
+	return nil
+}
+
+// Commit moves each stale file, and each file to be replaced, aside to a backup beside it, then renames each temporary file over its target.
+// Should any of it fail, every file is moved back and the temporary files are removed, leaving the directory as it was.
+// Once every file is in place the backups are removed, along with any directories the stale files leave empty.
+func (d *atomicDirSink) Commit() error {
+	backups := []pendingFile{}
+	placed := []string{}
+	err := func() error {
+		for _, path := range d.stale {
+			backup, err := moveAside(filepath.Join(d.root, filepath.FromSlash(path)))
+			if err != nil {
+				return err
+			}
+			backups = append(backups, backup)
+		}
+		for len(d.pending) > 0 {
+			file := d.pending[0]
+			backup, err := moveAside(file.target)
+			if err != nil {
+				return err
+			}
+			backups = append(backups, backup)
+			err = os.Rename(file.temp, file.target)
+			if err != nil {
+				return err
+			}
+			placed = append(placed, file.target)
+			d.pending = d.pending[1:]
+		}
+		return nil
+	}()
+	if err != nil {
+		for _, target := range placed {
+			os.Remove(target)
+		}
+		for i := len(backups) - 1; i >= 0; i-- {
+			if backups[i].temp != "" {
+				os.Rename(backups[i].temp, backups[i].target)
+			}
+		}
+		d.Abort()
+		return err
+	}
+	for _, backup := range backups {
+		if backup.temp != "" {
+			os.Remove(backup.temp)
+		}
+	}
+	for _, path := range d.stale {
+		removeEmptyDirs(d.root, filepath.Dir(filepath.Join(d.root, filepath.FromSlash(path))))
+	}
+	d.stale = nil
+	return nil
+}
+
+// Abort removes the temporary files, leaving the files they would have replaced untouched.
+func (d *atomicDirSink) Abort() error {
+	var err error
+	for _, file := range d.pending {
+		removeErr := os.Remove(file.temp)
+		if err == nil {
+			err = removeErr
+		}
+	}
+	d.pending = nil
+	return err
+}
+
+// moveAside renames the file at path to a backup beside it, returning the backup as a [pendingFile] whose target is the path.
+// The backup is empty if there was no file at path to move.
+func moveAside(path string) (pendingFile, error) {
+	_, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pendingFile{target: path}, nil
+	}
+	if err != nil {
+		return pendingFile{}, err
+	}
+	backup, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return pendingFile{}, err
+	}
+	backup.Close()
+	err = os.Rename(path, backup.Name())
+	if err != nil {
+		os.Remove(backup.Name())
+		return pendingFile{}, err
+	}
+	return pendingFile{temp: backup.Name(), target: path}, nil
+}
+
+// removeEmptyDirs removes the directory at dir, and each of its parents within root, for as long as they are empty.
+func removeEmptyDirs(root string, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// An archiveSink writes a zip or tar archive to a temporary file beside the path it replaces,
+// renaming the temporary file into place when committed.
+type archiveSink struct {
+	archive interface {
+		Sink
+		Close() error
+	}
+	file *os.File
+	path string
+}
+
+// newArchiveSink creates the temporary file that will replace the archive at path.
+func newArchiveSink(format OutputFormat, path string) (*archiveSink, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	sink := &archiveSink{file: file, path: path}
+	if format == OutputZip {
+		sink.archive = NewZipSink(file)
+	} else {
+		sink.archive = NewTarSink(file)
+	}
+	return sink, nil
+}
+
+func (a *archiveSink) WriteFile(path string, contents []byte) error {
+	return a.archive.WriteFile(path, contents)
+}
+
+// Commit finishes the archive and renames it into place.
+func (a *archiveSink) Commit() error {
+	err := a.archive.Close()
+	if err == nil {
+		err = a.file.Chmod(0644)
+	}
+	if err != nil {
+		a.Abort()
+		return err
+	}
+	err = a.file.Close()
+	if err != nil {
+		os.Remove(a.file.Name())
+		return err
+	}
+	err = os.Rename(a.file.Name(), a.path)
+	if err != nil {
+		os.Remove(a.file.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, leaving any previous archive untouched.
+func (a *archiveSink) Abort() error {
+	a.file.Close()
+	return os.Remove(a.file.Name())
+}
+
+// A DirSink writes each file to the directory it names, creating any missing parent directories.
+type DirSink string
+
+func (d DirSink) WriteFile(path string, contents []byte) error {
+	path = filepath.Join(string(d), filepath.FromSlash(path))
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+// A ZipSink writes each file to a zip archive. The archive is incomplete until the ZipSink is closed.
+type ZipSink struct {
+	w *zip.Writer
+}
+
+// NewZipSink returns a [ZipSink] writing a zip archive to the io.Writer.
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{w: zip.NewWriter(w)}
+}
+
+func (z *ZipSink) WriteFile(path string, contents []byte) error {
+	f, err := z.w.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(contents)
+	return err
+}
+
+// Close finishes writing the zip archive. It does not close the underlying io.Writer.
+func (z *ZipSink) Close() error {
+	return z.w.Close()
+}
+
+// A TarSink writes each file to a tar archive. The archive is incomplete until the TarSink is closed.
+type TarSink struct {
+	w *tar.Writer
+}
+
+// NewTarSink returns a [TarSink] writing a tar archive to the io.Writer.
+func NewTarSink(w io.Writer) *TarSink {
+	return &TarSink{w: tar.NewWriter(w)}
+}
+
+func (t *TarSink) WriteFile(path string, contents []byte) error {
+	err := t.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path,
+		Mode:     0644,
+		Size:     int64(len(contents)),
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.w.Write(contents)
+	return err
+}
+
+// Close finishes writing the tar archive. It does not close the underlying io.Writer.
+func (t *TarSink) Close() error {
+	return t.w.Close()
+}
+
+// A StreamSink writes each file to an io.Writer, one document after another.
+// Each document starts with a "--- # path" line naming its file, as in a multi-document YAML stream.
+type StreamSink struct {
+	w io.Writer
+}
+
+// NewStreamSink returns a [StreamSink] writing to the io.Writer.
+func NewStreamSink(w io.Writer) *StreamSink {
+	return &StreamSink{w: w}
+}
+
+func (s *StreamSink) WriteFile(path string, contents []byte) error {
+	_, err := fmt.Fprintf(s.w, "--- # %s\n", path)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(contents)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(contents, []byte("\n")) {
+		_, err = io.WriteString(s.w, "\n")
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package templater
+
+import (
+	"math"
+	"math/bits"
+)
+
+// hyperLogLogPrecision is the number of bits of a hash that pick the register of a [hyperLogLog].
+// Its 2^14 registers take 16 KiB, and estimate distinct counts to within about 1%.
+const hyperLogLogPrecision = 14
+
+// A hyperLogLog estimates the number of distinct hashes added to it, in a fixed amount of memory however many there are.
+//
+// Reference: https://en.wikipedia.org/wiki/HyperLogLog.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+// newHyperLogLog returns an empty [hyperLogLog].
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hyperLogLogPrecision)}
+}
+
+// add records a hash, which should be mixed with [mixHash] so that its bits are evenly distributed.
+func (h *hyperLogLog) add(hash uint64) {
+	register := hash >> (64 - hyperLogLogPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1))) + 1
+	if rank > h.registers[register] {
+		h.registers[register] = rank
+	}
+}
+
+// estimate returns the estimated number of distinct hashes added.
+// Small counts are estimated by linear counting of the empty registers, where the raw estimate is biased.
+func (h *hyperLogLog) estimate() int {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// mixHash scrambles the bits of an FNV hash, whose high bits are poorly distributed for short values.
+//
+// Reference: the 64 bit finaliser of MurmurHash3, https://github.com/aappleby/smhasher.
+func mixHash(hash uint64) uint64 {
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// signatureBits is the size of a [valueSignature]: 2^16 bits, taking 8 KiB.
+const signatureBits = 1 << 16
+
+// A valueSignature is a fixed size bitmap of the hashes of the values of a [Field], with a bit set for each.
+// One signature covering another means every value of the first was, most likely, also a value of the second.
+// False positives grow likelier as the covering signature fills up, but there are never false negatives.
+type valueSignature []uint64
+
+// newValueSignature returns an empty [valueSignature].
+func newValueSignature() valueSignature {
+	return make(valueSignature, signatureBits/64)
+}
+
+// add sets the bit of a hash.
+func (s valueSignature) add(hash uint64) {
+	bit := hash % signatureBits
+	s[bit/64] |= 1 << (bit % 64)
+}
+
+// coveredBy reports whether every bit set in the [valueSignature] is also set in the other.
+func (s valueSignature) coveredBy(other valueSignature) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for i := range s {
+		if s[i]&^other[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
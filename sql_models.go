@@ -4,7 +4,6 @@ import (
 	"embed"
 	"fmt"
 	"io"
-	"sort"
 	"strings"
 	"text/template"
 
@@ -20,6 +19,7 @@ var (
 // SQLTemplate is an intermediate data structure that represents the table to be rendered as a SQL Model in a DBT Project.
 type SQLTemplate struct {
 	Tags      string
+	Hooks     string
 	Columns   string
 	Source    string
 	Reference string
@@ -47,14 +47,20 @@ func GenerateReferenceSQL(table string) string {
 }
 
 // Generate the SQL required to declare, rename and typecast the columns in a table in a DBT Project Model.
-func GenerateColumnsSQL(f map[string]Field) string {
+// Columns classified as PII are wrapped in the masking macro when masking with [MaskMacro].
+// Column names that are reserved words, or that start with a digit, are made valid as configured by [WithDialect].
+// Columns are sorted as configured by [WithColumnOrder].
+func GenerateColumnsSQL(f map[string]Field, opts ...ModelOption) string {
+	config := newModelConfig(opts...)
 	fields := maps.Values(f)
 	column_data := ""
-	sort.Slice(fields, func(i, j int) bool {
-		return fields[i].Node < fields[j].Node
-	})
+	config.order.sortFields(fields)
 	for _, field := range fields {
-		column_data += fmt.Sprintf(`  ,%s::%s AS %s`, EscapePath(field.Path), field.InferredType, NormaliseKey(field.Node))
+		column := fmt.Sprintf(`%s::%s`, EscapePath(field.Path), field.InferredType)
+		if config.masking == MaskMacro && field.PII != "" {
+			column = GenerateMaskedColumnSQL(column, field.PII)
+		}
+		column_data += fmt.Sprintf(`  ,%s AS %s`, column, config.columnSQL(field.Node))
 		column_data += "\n"
 	}
 	// strip the first comma out.
@@ -68,13 +74,17 @@ func GenerateColumnsSQL(f map[string]Field) string {
 // writeTransformSQLModel writes a Transform SQL Model to the io.Writer.
 // Transform models include the following:
 //   - A config block with tags.
+//   - Optionally, a config block with post hooks applying masking policies.
 //   - A list of columns to be transformed, with typecasting and key sanitisation.
 //   - A source table relation statement.
-func writeTransformSQLModel(table Table, w io.Writer) error {
+func writeTransformSQLModel(table Table, w io.Writer, opts ...ModelOption) error {
 	sqlTemplate := SQLTemplate{
 		Tags:    GenerateTagsSQL(table.Project, table.Name),
-		Columns: GenerateColumnsSQL(table.Fields),
-		Source:  GenerateSourceSQL(table.Project, table.Name),
+		Columns: GenerateColumnsSQL(table.Fields, opts...),
+		Source:  GenerateSourceSQL(table.source()),
+	}
+	if newModelConfig(opts...).masking == MaskPolicy {
+		sqlTemplate.Hooks = GenerateMaskingPolicyHooksSQL(table, opts...)
 	}
 	tpl, err := template.New("transform_template.gohtml").ParseFS(fileSystem, "templates/transform_template.gohtml")
 	if err != nil {
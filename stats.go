@@ -0,0 +1,337 @@
+package templater
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"unicode/utf8"
+
+	"cuelang.org/go/cue"
+	"golang.org/x/exp/maps"
+)
+
+// maxDistinctTracked caps the number of distinct values counted exactly per [Field].
+// Beyond it, the distinct count is estimated by a [hyperLogLog] instead.
+const maxDistinctTracked = 1 << 14
+
+// maxEnumerationValues caps the number of distinct values [FieldStats.Values] lists, which is as many as a [tableCache] keeps.
+const maxEnumerationValues = 100
+
+// maxValuesTracked caps the number of distinct values whose text is remembered per [Field].
+// Beyond it, the observed values are no longer known completely.
+const maxValuesTracked = 1000
+
+// FieldStats accumulates observations about the values of a [Field] seen during inference.
+// Its memory is bounded however many rows are observed: at most [maxDistinctTracked] hashes counted exactly (around 320 KiB),
+// a 16 KiB [hyperLogLog] once there are more, an 8 KiB [valueSignature], and the text of at most [maxValuesTracked] values.
+//
+// NonNull: The number of rows in which the field held a value.
+// Empty strings count as nulls, as that is how Snowflake loads empty CSV fields by default.
+type FieldStats struct {
+	NonNull        int
+	distinct       map[uint64]struct{}
+	overflow       bool
+	sketch         *hyperLogLog
+	signature      valueSignature
+	values         map[string]int
+	valuesOverflow bool
+	kinds          map[string]int
+	numbers        int
+	sum            float64
+	min, max       float64
+	strings        int
+	minString      string
+	maxString      string
+	lengthSum      int
+	minLength      int
+	maxLength      int
+	lengths        map[int]int
+	summary        *statsSummary
+}
+
+// newFieldStats returns an empty [FieldStats].
+func newFieldStats() *FieldStats {
+	return &FieldStats{
+		distinct: make(map[uint64]struct{}),
+		values:   make(map[string]int),
+		kinds:    make(map[string]int),
+		lengths:  make(map[int]int),
+	}
+}
+
+// observe records a single value of the [Field].
+func (s *FieldStats) observe(c cue.Value) {
+	value, ok := valueString(c)
+	if !ok {
+		return
+	}
+	s.NonNull++
+	s.kinds[SnowflakeTypes[c.IncompleteKind().String()]]++
+	s.observeNumber(c)
+	s.observeString(c)
+
+	// Once too many values are seen, keep counting the ones we know so the most common are still approximated.
+	if _, seen := s.values[value]; seen || len(s.values) < maxValuesTracked {
+		s.values[value]++
+	} else {
+		s.valuesOverflow = true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	hash := mixHash(h.Sum64())
+	if s.signature == nil {
+		s.signature = newValueSignature()
+	}
+	s.signature.add(hash)
+	if s.overflow {
+		s.sketch.add(hash)
+		return
+	}
+	s.distinct[hash] = struct{}{}
+	if len(s.distinct) > maxDistinctTracked {
+		s.overflow = true
+		s.sketch = newHyperLogLog()
+		for hash := range s.distinct {
+			s.sketch.add(hash)
+		}
+		s.distinct = nil
+	}
+}
+
+// observeNumber records the range and sum of numeric values.
+func (s *FieldStats) observeNumber(c cue.Value) {
+	if c.IncompleteKind()&cue.NumberKind == 0 {
+		return
+	}
+	f, err := c.Float64()
+	if err != nil {
+		return
+	}
+	if s.numbers == 0 || f < s.min {
+		s.min = f
+	}
+	if s.numbers == 0 || f > s.max {
+		s.max = f
+	}
+	s.numbers++
+	s.sum += f
+}
+
+// observeString records the range and lengths of string values.
+func (s *FieldStats) observeString(c cue.Value) {
+	str, err := c.String()
+	if err != nil {
+		return
+	}
+	length := utf8.RuneCountInString(str)
+	if s.strings == 0 || str < s.minString {
+		s.minString = str
+	}
+	if s.strings == 0 || str > s.maxString {
+		s.maxString = str
+	}
+	if s.strings == 0 || length < s.minLength {
+		s.minLength = length
+	}
+	if s.strings == 0 || length > s.maxLength {
+		s.maxLength = length
+	}
+	s.strings++
+	s.lengthSum += length
+	s.lengths[lengthBucket(length)]++
+}
+
+// lengthBucket returns the smallest power of two that is at least the given length.
+func lengthBucket(length int) int {
+	bucket := 1
+	for bucket < length {
+		bucket *= 2
+	}
+	return bucket
+}
+
+// Distinct returns the number of distinct non-null values observed.
+// It reports false if there were too many distinct values to count exactly, in which case the count is an estimate.
+func (s *FieldStats) Distinct() (int, bool) {
+	if s.summary != nil {
+		return s.summary.Distinct, s.summary.DistinctExact
+	}
+	if s.overflow {
+		return s.sketch.estimate(), false
+	}
+	return len(s.distinct), true
+}
+
+// TopValues returns up to k of the most common non-null values observed, with how often they occurred.
+// Ties are broken by the values themselves, so the result is stable.
+// If there were too many distinct values to remember them all, the counts are approximate.
+func (s *FieldStats) TopValues(k int) []ValueCount {
+	if s.summary != nil {
+		counts := s.summary.TopValues
+		if len(counts) > k {
+			counts = counts[:k]
+		}
+		return append([]ValueCount{}, counts...)
+	}
+	counts := make([]ValueCount, 0, len(s.values))
+	for value, count := range s.values {
+		counts = append(counts, ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	if len(counts) > k {
+		counts = counts[:k]
+	}
+	return counts
+}
+
+// TypeConflicts returns the number of non-null values observed that are not of the given Snowflake type.
+// INTEGER values are not considered to conflict with a FLOAT type.
+func (s *FieldStats) TypeConflicts(inferredType string) int {
+	matching := s.kinds[inferredType]
+	if inferredType == "FLOAT" {
+		matching += s.kinds["INTEGER"]
+	}
+	return s.NonNull - matching
+}
+
+// Values returns the distinct non-null values observed, in sorted order.
+// It reports false if there were more than [maxEnumerationValues] of them.
+func (s *FieldStats) Values() ([]string, bool) {
+	if s.valuesOverflow || len(s.values) > maxEnumerationValues {
+		return nil, false
+	}
+	return s.sampleValues()
+}
+
+// subsetOf reports whether every distinct non-null value observed was, most likely, also observed by the other [FieldStats].
+// Values are compared by their [valueSignature], so it may rarely report true when a value is missing from the other.
+func (s *FieldStats) subsetOf(other *FieldStats) bool {
+	if s.signature == nil || other == nil || other.signature == nil {
+		return false
+	}
+	return s.signature.coveredBy(other.signature)
+}
+
+// sampleValues returns the distinct non-null values remembered, in sorted order.
+// It reports false if these are only a sample of the values observed.
+func (s *FieldStats) sampleValues() ([]string, bool) {
+	values := maps.Keys(s.values)
+	sort.Strings(values)
+	return values, !s.valuesOverflow
+}
+
+// A statsSummary is what a [tableCache] keeps of the [FieldStats] of a field: its counts, ranges and [valueSignature],
+// and no more of its values than generating the project needs. It never holds the hashes of the values.
+//
+// Distinct: The number of distinct values, which is an estimate when DistinctExact is false.
+//
+// Values: Each distinct value and how often it occurred, for fields with at most [maxEnumerationValues] of them.
+//
+// TopValues: The most common values, as many as a [Profile] lists.
+//
+// PII: The [PIICategory] most of the values looked like, so that the field can be classified without them.
+//
+// Redacted: Whether the values were left out, along with the string and numeric ranges, because the field holds PII.
+type statsSummary struct {
+	NonNull       int            `json:"non_null"`
+	Distinct      int            `json:"distinct"`
+	DistinctExact bool           `json:"distinct_exact"`
+	Signature     []uint64       `json:"signature,omitempty"`
+	Kinds         map[string]int `json:"kinds"`
+	Numbers       int            `json:"numbers"`
+	Sum           float64        `json:"sum"`
+	Min           float64        `json:"min"`
+	Max           float64        `json:"max"`
+	Strings       int            `json:"strings"`
+	MinString     string         `json:"min_string,omitempty"`
+	MaxString     string         `json:"max_string,omitempty"`
+	LengthSum     int            `json:"length_sum"`
+	MinLength     int            `json:"min_length"`
+	MaxLength     int            `json:"max_length"`
+	Lengths       map[int]int    `json:"lengths"`
+	Values        map[string]int `json:"values,omitempty"`
+	TopValues     []ValueCount   `json:"top_values,omitempty"`
+	PII           PIICategory    `json:"pii,omitempty"`
+	Redacted      bool           `json:"redacted,omitempty"`
+}
+
+// summarise returns the [statsSummary] of the [FieldStats], leaving out its values if redact is set.
+func (s *FieldStats) summarise(redact bool) *statsSummary {
+	distinct, exact := s.Distinct()
+	summary := &statsSummary{
+		NonNull:       s.NonNull,
+		Distinct:      distinct,
+		DistinctExact: exact,
+		Signature:     s.signature,
+		Kinds:         s.kinds,
+		Numbers:       s.numbers,
+		Sum:           s.sum,
+		Strings:       s.strings,
+		LengthSum:     s.lengthSum,
+		MinLength:     s.minLength,
+		MaxLength:     s.maxLength,
+		Lengths:       s.lengths,
+		PII:           s.valuePII(),
+		Redacted:      redact,
+	}
+	if redact {
+		return summary
+	}
+	summary.Min, summary.Max = s.min, s.max
+	summary.MinString, summary.MaxString = s.minString, s.maxString
+	summary.TopValues = s.TopValues(profileTopValues)
+	if _, complete := s.Values(); complete {
+		summary.Values = s.values
+	}
+	return summary
+}
+
+// restore returns [FieldStats] standing in for those the [statsSummary] summarises.
+// They answer as the summarised FieldStats would, but can't go on to observe any more values.
+func (j *statsSummary) restore() *FieldStats {
+	s := newFieldStats()
+	s.summary = j
+	s.NonNull, s.signature = j.NonNull, valueSignature(j.Signature)
+	s.valuesOverflow = j.Values == nil && j.NonNull > 0
+	maps.Copy(s.values, j.Values)
+	maps.Copy(s.kinds, j.Kinds)
+	maps.Copy(s.lengths, j.Lengths)
+	s.numbers, s.sum, s.min, s.max = j.Numbers, j.Sum, j.Min, j.Max
+	s.strings, s.minString, s.maxString = j.Strings, j.MinString, j.MaxString
+	s.lengthSum, s.minLength, s.maxLength = j.LengthSum, j.MinLength, j.MaxLength
+	return s
+}
+
+// redacted reports whether the values of the [FieldStats] were left out of the [statsSummary] they were restored from.
+func (s *FieldStats) redacted() bool {
+	return s.summary != nil && s.summary.Redacted
+}
+
+// Nulls returns the number of null values, given the number of rows observed in the [Table].
+// Rows in which the field was absent altogether count as nulls.
+func (s *FieldStats) Nulls(rows int) int {
+	return rows - s.NonNull
+}
+
+// valueString returns the textual representation of a [cue.Value].
+// It reports false if the value is null or an empty string.
+func valueString(c cue.Value) (string, bool) {
+	if c.IncompleteKind() == cue.NullKind {
+		return "", false
+	}
+	if s, err := c.String(); err == nil {
+		return s, s != ""
+	}
+	return fmt.Sprint(c), true
+}
+
+// A ValueCount is a value observed during inference, and the number of times it was seen.
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
@@ -2,33 +2,288 @@ package templater
 
 import (
 	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"cuelang.org/go/cue"
-	"github.com/go-gota/gota/dataframe"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/go-gota/gota/series"
 )
 
-// tableIterator returns a [cue.Iterator] for a given [io.Reader].
-// It will attempt to parse the [io.Reader] as a CSV, transform it into a JSON string
-// and finally parse the JSON string into a [cue.Iterator].
-// We will use this [cue.Iterator] to walk through the table values and infer the fields types.
-func tableIterator(c *cue.Context, r io.Reader) (cue.Iterator, error) {
-	buf := bytes.NewBuffer([]byte{})
-	df := dataframe.ReadCSV(r, dataframe.WithLazyQuotes(true))
-	err := df.WriteJSON(buf)
+// nanValues are the CSV fields read as nulls, whatever the type of their column.
+var nanValues = map[string]bool{"NA": true, "NaN": true, "<nil>": true}
+
+// newCSVReader returns a [csv.Reader] configured as templater reads CSVs, with lazy quotes if asked for.
+func newCSVReader(r io.Reader, lazyQuotes bool) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = lazyQuotes
+	reader.ReuseRecord = true
+	return reader
+}
+
+// A csvSchema describes the columns of a CSV, as detected by a first pass through it.
+//
+// Rows: The number of rows sampled.
+//
+// TotalRows: The number of rows in the CSV, or zero where sampling stopped reading before the end of the CSV.
+//
+// LazyQuotes: Whether the CSV had to be read with lazy quotes, as its quoting is invalid.
+//
+// CRLF: Whether the lines of the CSV end in a carriage return and a line feed, rather than just a line feed.
+type csvSchema struct {
+	names      []string
+	types      []series.Type
+	rows       int
+	totalRows  int
+	lazyQuotes bool
+	crlf       bool
+}
+
+// A csvFormat is how the CSV of a [Table] is written, as detected while inferring it, so that it can be loaded the same way.
+//
+// Columns: The header of the CSV, with any missing or duplicated names replaced as they were when read.
+type csvFormat struct {
+	Columns    []string `json:"columns"`
+	CRLF       bool     `json:"crlf,omitempty"`
+	LazyQuotes bool     `json:"lazy_quotes,omitempty"`
+}
+
+// A lineEndingReader notes whether the first line read through it ends in a carriage return and a line feed.
+type lineEndingReader struct {
+	r    io.Reader
+	done bool
+	crlf bool
+	last byte
+}
+
+func (l *lineEndingReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.done || n == 0 {
+		return n, err
+	}
+	if i := bytes.IndexByte(p[:n], '\n'); i >= 0 {
+		l.done = true
+		l.crlf = (i > 0 && p[i-1] == '\r') || (i == 0 && l.last == '\r')
+	}
+	l.last = p[n-1]
+	return n, err
+}
+
+// detectCSVSchema streams through a CSV once, detecting the type of each column the same way as [dataframe.ReadCSV].
+// A column is a STRING if any of its fields is, otherwise a BOOLEAN, FLOAT or INTEGER, in that order.
+// Only the rows chosen by the [Sampling] are considered.
+// Only the header and the kinds of value seen in each column are kept, so memory doesn't grow with the size of the CSV.
+func detectCSVSchema(r io.Reader, sampling Sampling, lazyQuotes bool) (csvSchema, error) {
+	schema := csvSchema{lazyQuotes: lazyQuotes}
+	lines := &lineEndingReader{r: r}
+	var hasInts, hasFloats, hasBools, hasStrings []bool
+	header, total, complete, err := sampleRecords(newCSVReader(lines, lazyQuotes), sampling, func(header, record []string, _ int) error {
+		if schema.names == nil {
+			schema.names = append([]string{}, header...)
+			hasInts = make([]bool, len(header))
+			hasFloats = make([]bool, len(header))
+			hasBools = make([]bool, len(header))
+			hasStrings = make([]bool, len(header))
+		}
+		schema.rows++
+		for i, field := range record {
+			if field == "" || nanValues[field] {
+				continue
+			}
+			if _, err := strconv.Atoi(field); err == nil {
+				hasInts[i] = true
+				continue
+			}
+			if _, err := strconv.ParseFloat(field, 64); err == nil {
+				hasFloats[i] = true
+				continue
+			}
+			if field == "true" || field == "false" {
+				hasBools[i] = true
+				continue
+			}
+			hasStrings[i] = true
+		}
+		return nil
+	})
 	if err != nil {
-		return cue.Iterator{}, err
+		return csvSchema{}, err
+	}
+	if total == 0 {
+		schema.names = append([]string{}, header...)
+		schema.crlf = lines.crlf
+		fixColumnNames(schema.names)
+		return schema, nil
+	}
+	if schema.rows == 0 {
+		return csvSchema{}, fmt.Errorf("none of the %d rows were sampled", total)
+	}
+	if complete {
+		schema.totalRows = total
+	}
+	schema.crlf = lines.crlf
+	schema.types = make([]series.Type, len(schema.names))
+	for i := range schema.names {
+		switch {
+		case hasStrings[i]:
+			schema.types[i] = series.String
+		case hasBools[i]:
+			schema.types[i] = series.Bool
+		case hasFloats[i]:
+			schema.types[i] = series.Float
+		case hasInts[i]:
+			schema.types[i] = series.Int
+		default:
+			schema.types[i] = series.String
+		}
+	}
+	fixColumnNames(schema.names)
+	return schema, nil
+}
+
+// fixColumnNames names any unnamed columns X0, X1 and so on, and suffixes duplicated names with _0, _1 and so on,
+// the same way as [dataframe.ReadCSV].
+func fixColumnNames(names []string) {
+	taken := func(name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	duplicates := make(map[string][]int)
+	missing := []int{}
+	for i, name := range names {
+		if name == "" {
+			missing = append(missing, i)
+			continue
+		}
+		duplicates[name] = append(duplicates[name], i)
+	}
+	counter := 0
+	for _, i := range missing {
+		proposed := fmt.Sprintf("X%d", counter)
+		for taken(proposed) {
+			counter++
+			proposed = fmt.Sprintf("X%d", counter)
+		}
+		names[i] = proposed
+		counter++
+	}
+	duplicated := []string{}
+	for name, places := range duplicates {
+		if len(places) > 1 {
+			duplicated = append(duplicated, name)
+		}
+	}
+	sort.Strings(duplicated)
+	for _, name := range duplicated {
+		counter := 0
+		for _, i := range duplicates[name] {
+			proposed := fmt.Sprintf("%s_%d", name, counter)
+			for taken(proposed) {
+				counter++
+				proposed = fmt.Sprintf("%s_%d", name, counter)
+			}
+			names[i] = proposed
+			counter++
+		}
+	}
+}
+
+// fieldValue converts a CSV field to the value of its column's type, or nil where the field is null or doesn't parse.
+func fieldValue(field string, columnType series.Type) any {
+	if nanValues[field] {
+		return nil
+	}
+	switch columnType {
+	case series.Int:
+		i, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		return i
+	case series.Float:
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil
+		}
+		return f
+	case series.Bool:
+		switch strings.ToLower(field) {
+		case "true", "t", "1":
+			return true
+		case "false", "f", "0":
+			return false
+		}
+		return nil
+	}
+	return field
+}
+
+// rowsPerContext is the number of rows compiled in a [cue.Context] before it is replaced.
+// A context holds on to everything compiled in it, so reusing one for every row would hold the whole table in memory.
+const rowsPerContext = 1000
+
+// streamRows streams through a CSV of the given [csvSchema] a second time,
+// calling fn with each row chosen by the [Sampling] as a [cue.Value] of an object whose keys keep the order of the columns in the header,
+// along with the line of the CSV the row starts on.
+// Only one row is held in memory at a time, or the rows of the reservoir when sampling with [SampleReservoir].
+func streamRows(r io.Reader, schema csvSchema, sampling Sampling, fn func(row cue.Value, line int) error) error {
+	keys := make([][]byte, len(schema.names))
+	for i, name := range schema.names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
 	}
-	cueValue := c.CompileBytes(buf.Bytes())
-	return cueValue.List()
+	buf := new(bytes.Buffer)
+	var c *cue.Context
+	rows := 0
+	_, _, _, err := sampleRecords(newCSVReader(r, schema.lazyQuotes), sampling, func(_, record []string, line int) error {
+		if rows%rowsPerContext == 0 {
+			c = cuecontext.New()
+		}
+		rows++
+		buf.Reset()
+		buf.WriteString("{")
+		for i, field := range record {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			value, err := json.Marshal(fieldValue(field, schema.types[i]))
+			if err != nil {
+				return err
+			}
+			buf.Write(keys[i])
+			buf.WriteString(":")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+		row := c.CompileBytes(buf.Bytes())
+		if row.Err() != nil {
+			return row.Err()
+		}
+		return fn(row, line)
+	})
+	return err
 }
 
 // generateTables will walk through the given [inputDir] and generate the [Table]s.
 // It will return a map of *[Table]s keyed by the table name.
+// Tables in subdirectories belong to a source named after their directory.
 // Once we have this intermediate representation, we no longer need the tables on disk.
 func generateTables(fsys fs.FS, projectName string, unpackPaths ...string) ([]*Table, error) {
 	tables := []*Table{}
@@ -36,16 +291,19 @@ func generateTables(fsys fs.FS, projectName string, unpackPaths ...string) ([]*T
 
 		if filepath.Ext(path) == ".csv" && !info.IsDir() {
 
-			f, err := fsys.Open(path)
-			if err != nil {
-				return err
-			}
-			contents := io.Reader(f)
 			table := Table{
-				Name:        CleanTableName(info.Name()),
-				Project:     projectName,
-				Fields:      make(map[string]Field),
-				rawContents: contents,
+				Name:    CleanTableName(info.Name()),
+				Project: projectName,
+				File:    path,
+				Fields:  make(map[string]Field),
+				open: func() (io.ReadCloser, error) {
+					return fsys.Open(path)
+				},
+			}
+			if dir := filepath.Dir(path); dir != "." {
+				table.Source = CleanSourceName(dir)
+				table.SourceTable = table.Name
+				table.Name = fmt.Sprintf("%s_%s", table.Source, table.SourceTable)
 			}
 			tables = append(tables, &table)
 		}
@@ -57,38 +315,137 @@ func generateTables(fsys fs.FS, projectName string, unpackPaths ...string) ([]*T
 	return tables, nil
 }
 
-// generateTableFields will iterate over the CUE representation of the table data and infer the fields types.
-func generateTableFields(table *Table, c *cue.Context, unpackPaths ...string) error {
-	iterator, err := tableIterator(c, table.rawContents)
+// generateTableFields will stream through the rows of the table twice, first detecting the type of each column,
+// and then inferring the fields types from the CUE representation of each row.
+// Only the rows chosen by the [Sampling] of the table are read.
+// Reading stops once the context is done.
+// A CSV with invalid quoting is read again with lazy quotes, adding a [Diagnostic] about it to the table's collection.
+// A CSV with no rows after its header is given a VARCHAR field for each column, also with a Diagnostic about it.
+// Errors are returned as a [TableError] naming the file of the table.
+func generateTableFields(ctx context.Context, table *Table, unpackPaths ...string) error {
+	detect := func(lazyQuotes bool) (csvSchema, error) {
+		var schema csvSchema
+		err := table.read(ctx, func(r io.Reader) error {
+			var err error
+			schema, err = detectCSVSchema(r, table.Sampling, lazyQuotes)
+			return err
+		})
+		return schema, err
+	}
+	schema, err := detect(false)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && (errors.Is(parseErr.Err, csv.ErrBareQuote) || errors.Is(parseErr.Err, csv.ErrQuote)) {
+		table.line = parseErr.Line
+		table.diagnose(SeverityWarning, MalformedQuotes, "",
+			fmt.Sprintf("%v at column %d, so the file was read with lazy quotes", parseErr.Err, parseErr.Column),
+			table.sourceLine(ctx, parseErr.Line))
+		schema, err = detect(true)
+	}
 	if err != nil {
-		return err
+		return &TableError{File: table.File, Err: err}
+	}
+	table.TotalRows = schema.totalRows
+	table.format = csvFormat{Columns: schema.names, CRLF: schema.crlf, LazyQuotes: schema.lazyQuotes}
+	if schema.rows == 0 {
+		table.diagnose(SeverityWarning, EmptyCSV, "", "no rows after the header, so every column fell back to VARCHAR", "")
+		table.inferEmptyColumns(schema.names)
+		return nil
 	}
-	err = table.InferFields(iterator, unpackPaths...)
+	err = table.read(ctx, func(r io.Reader) error {
+		return streamRows(r, schema, table.Sampling, func(row cue.Value, line int) error {
+			table.line = line
+			return table.InferRow(row, unpackPaths...)
+		})
+	})
 	if err != nil {
-		return err
+		return &TableError{File: table.File, Err: err}
+	}
+	table.line = 0
+	for _, field := range table.Fields {
+		if field.InferredType == SnowflakeTypes["null"] {
+			table.diagnose(SeverityWarning, AllNull, field.Path, "every value was null, so the type fell back to VARCHAR", "")
+		}
 	}
 	return nil
 }
 
-// writeTableModel will write a given Table to disk in its transform/public SQL representations.
-func writeTableModel(table *Table) error {
-	transformFile := fmt.Sprintf("output/transform/TRANS01_%s.sql", table.Name)
-	file, err := os.Create(transformFile)
+// inferEmptyColumns adds a VARCHAR field for each column of a CSV with no rows, there being no values to infer their types from.
+func (t *Table) inferEmptyColumns(columns []string) {
+	for i, column := range columns {
+		path := cue.MakePath(cue.Str(column)).String()
+		t.Fields[path] = Field{
+			Node:         t.Naming.Name(path),
+			Path:         EscapePath(path),
+			InferredType: SnowflakeTypes["null"],
+			Stats:        newFieldStats(),
+			Ordinal:      i,
+			seen:         i + 1,
+		}
+	}
+}
+
+// read opens the file of the [Table], passing it to fn and closing it once fn returns.
+// Reads from the file fail with the context's error once the context is done.
+func (t *Table) read(ctx context.Context, fn func(io.Reader) error) error {
+	f, err := t.open()
 	if err != nil {
 		return err
 	}
-	err = writeTransformSQLModel(*table, file)
+	defer f.Close()
+	return fn(contextReader{ctx: ctx, r: f})
+}
+
+// A contextReader is an [io.Reader] that fails with the error of its context once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// writeTableModel will write a given Table to the [Sink] in its transform/public SQL representations.
+// Tables in subdirectories of the project are written to the matching subdirectories of the output.
+func writeTableModel(sink Sink, table *Table, opts ...ModelOption) error {
+	dir := path.Dir(table.File)
+	buf := new(bytes.Buffer)
+	err := writeTransformSQLModel(*table, buf, opts...)
 	if err != nil {
 		return err
 	}
-	publicFile := fmt.Sprintf("output/public/%s.sql", table.Name)
-	file, err = os.Create(publicFile)
+	err = sink.WriteFile(path.Join("transform", dir, fmt.Sprintf("TRANS01_%s.sql", table.Name)), buf.Bytes())
 	if err != nil {
 		return err
 	}
-	err = writePublicSQLModel(*table, file)
+	buf.Reset()
+	err = writePublicSQLModel(*table, buf)
 	if err != nil {
 		return err
 	}
-	return nil
+	return sink.WriteFile(path.Join("public", dir, fmt.Sprintf("%s.sql", table.Name)), buf.Bytes())
+}
+
+// source returns the DBT source the [Table] belongs to, and the name of the table within it.
+func (t Table) source() (string, string) {
+	source, name := t.Source, t.SourceTable
+	if source == "" {
+		source = t.Project
+	}
+	if name == "" {
+		name = t.Name
+	}
+	return source, name
+}
+
+// sourceSchema returns the schema the raw table of the [Table] is landed in:
+// STAGING for tables at the top of the project, or the schema named after the source of the table.
+func (t Table) sourceSchema() string {
+	if t.Source != "" {
+		return t.Source
+	}
+	return "STAGING"
 }
@@ -1,13 +1,16 @@
 package templater
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/signal"
 	"path/filepath"
-
-	"cuelang.org/go/cue/cuecontext"
+	"time"
 )
 
 // A Field represents a column and information about how it should be transformed.
@@ -17,61 +20,192 @@ import (
 // Path: Represents the pre-transformation path to the data in the source table.
 //
 // InferType: Represents the current best guess at Snowflake type inferred from exemplars.
+//
+// Stats: Represents the observations made about the values of the field during inference.
+//
+// PII: Represents the kind of personally identifiable information the field holds, if any.
+//
+// Ordinal: Represents the position of the source column in the table.
+// Fields unpacked from JSON share the position of the column they were unpacked from.
 type Field struct {
 	Node         string
 	Path         string
 	InferredType string
+	Stats        *FieldStats
+	PII          PIICategory
+	Ordinal      int
+	seen         int
 }
 
 // A Table represents a source table.
 // It is the intermediate representation of the untyped semi-structured data.
+//
+// File: The path of the file the table was read from.
+//
+// Rows: The number of rows observed during inference.
+//
+// Sampling: The [Sampling] used to choose the rows observed during inference.
+//
+// TotalRows: The number of rows in the file, or zero where sampling stopped reading before the end of the file.
+//
+// Naming: The [NamingConvention] used to name the target column of each field.
+//
+// Source: The DBT source the table belongs to, named after the directory its file was found in.
+// Tables at the top of the project belong to the source named after the Project, and leave Source empty.
+//
+// SourceTable: The name of the table within its Source, when it differs from Name.
+// Tables in a directory are named after their Source as well, so that their names are unique across sources.
 type Table struct {
 	Name        string
 	Project     string
+	Source      string
+	SourceTable string
+	File        string
 	Fields      map[string]Field
-	rawContents io.Reader
+	Rows        int
+	Sampling    Sampling
+	TotalRows   int
+	Naming      NamingConvention
+	dialect     Dialect
+	identifiers IdentifierStrategy
+	open        func() (io.ReadCloser, error)
+	columns     map[string]int
+	seen        int
+	diagnostics *Diagnostics
+	line        int
+	format      csvFormat
 }
 
-// generateProject given a [fs.FS] of CSV's and an optional list of fields to unpack, will generate the project.
-func generateProject(fsys fs.FS, projectName string, unpackPaths ...string) error {
-	c := cuecontext.New()
-	tables, err := generateTables(fsys, projectName, unpackPaths...)
-	if err != nil {
-		return err
-	}
-	for _, table := range tables {
-		err := generateTableFields(table, c, unpackPaths...)
-		if err != nil {
-			return err
-		}
-	}
-
-	models := GenerateProjectModel(tables)
-	sources := generateProjectSources(tables, projectName)
-
-	return writeProject(c, models, sources, tables)
+// Options configures a run of the templater.
+//
+// Input: The [fs.FS] of CSV's to infer the project from. CSV's in subdirectories belong to a source named after their directory.
+//
+// Project: The name of the DBT source the tables belong to.
+//
+// UnpackPaths: The columns that hold JSON objects, capable of further unpacking.
+//
+// Lockfile: The path of the inference [Lockfile], written after each run of [Main] and read when checking for drift.
+//
+// Tests: The [TestPolicy] used to suggest tests for the columns of the transform models.
+//
+// Relationships: Whether to infer the [EntityGraph] across tables, suggesting relationships tests and reporting on it.
+//
+// Profile: Whether to write a [Profile] of the values observed in each table.
+//
+// DDL: Whether to write the Snowflake DDL to land the CSV of each table in its source schema.
+//
+// Contracts: Whether to enforce DBT contracts on the transform models, giving each column its data type in the Dialect.
+//
+// TargetDDL: Whether to write the DDL creating the typed target table of each transform model, in the Dialect.
+//
+// CUE: Whether to write the inferred schema of each table as a CUE definition, to validate future exports of it.
+//
+// CUEEnums: The number of distinct values at or below which a STRING field is an enumeration of them in its CUE definition.
+// Zero makes no enumerations.
+//
+// PII: Whether to detect the columns holding personally identifiable information, tagging them in the models.
+//
+// Masking: How the columns holding personally identifiable information are masked. Masking implies PII detection.
+//
+// Collisions: How fields whose names collide after normalisation are renamed.
+//
+// Dialect: The SQL [Dialect] the identifiers must be valid in. The zero value is [Snowflake].
+//
+// Identifiers: How identifiers that are reserved words, or that start with a digit, are made valid in the Dialect.
+//
+// Naming: The [NamingConvention] used to name the target columns.
+//
+// Order: The [ColumnOrder] of the columns of each model.
+//
+// Sampling: The [Sampling] used to choose the rows of each table that are read during inference.
+//
+// Workers: The number of tables inferred at once. The zero value infers one table per CPU at once.
+//
+// FailOn: The [Severity] of [Diagnostic] that fails the run. The zero value never fails a run for its diagnostics.
+//
+// CacheDir: The directory a summary of what is inferred from each file is cached in, so that files unchanged since a previous run aren't inferred again.
+// The summary is derived from the values of the files, so the directory deserves the same care as the files themselves.
+// The zero value doesn't cache anything.
+type Options struct {
+	Input         fs.FS
+	Project       string
+	UnpackPaths   []string
+	Lockfile      string
+	Tests         TestPolicy
+	Relationships bool
+	Profile       bool
+	DDL           bool
+	Contracts     bool
+	TargetDDL     bool
+	CUE           bool
+	CUEEnums      int
+	PII           bool
+	Masking       MaskingMode
+	Collisions    CollisionStrategy
+	Dialect       Dialect
+	Identifiers   IdentifierStrategy
+	Naming        NamingConvention
+	Order         ColumnOrder
+	Sampling      Sampling
+	Workers       int
+	FailOn        Severity
+	CacheDir      string
 }
 
-// createProjectDirectories will create the necessary project directories.
-// This is a noop if the directories already exist.
-func createProjectDirectories() error {
-	_, err := os.Stat("output")
+// defaultLockfile is where the inference [Lockfile] is kept unless otherwise specified.
+const defaultLockfile = "output/templater.lock.json"
+
+// inferProject given the [Options] for the run, will generate the [Table]s of its Input and infer their fields.
+// Any tables or fields whose names collide are renamed, with each [Rename] returned.
+// Tables are inferred concurrently, and if any fail, the errors of all of them are returned as [TableErrors].
+// Once the context is done, no more tables are inferred.
+// Anything that degrades the inference without failing it is added to the [Diagnostics].
+// Given a [tableCache], only the tables whose files changed since they were cached are inferred again.
+func inferProject(ctx context.Context, opts Options, diagnostics *Diagnostics, cache *tableCache) ([]*Table, []Rename, error) {
+	tables, err := generateTables(opts.Input, opts.Project, opts.UnpackPaths...)
 	if err != nil {
-		err := os.Mkdir("output", os.ModePerm)
-		if err != nil {
-			return err
+		return nil, nil, err
+	}
+	renames := ResolveTableCollisions(tables)
+	err = forEachTable(ctx, tables, opts.Workers, func(table *Table) error {
+		table.Naming = opts.Naming
+		table.Sampling = opts.Sampling
+		if cache != nil {
+			return cache.infer(ctx, opts.Input, table, diagnostics, opts.UnpackPaths...)
 		}
+		table.diagnostics = diagnostics
+		return generateTableFields(ctx, table, opts.UnpackPaths...)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	_, err = os.Stat("output/transform")
+	err = cache.retain(tables)
 	if err != nil {
-		err := os.Mkdir("output/transform", os.ModePerm)
-		if err != nil {
-			return err
-		}
+		return nil, nil, err
+	}
+	for _, table := range tables {
+		table.dialect, table.identifiers = opts.dialect(), opts.Identifiers
+		renames = append(renames, ResolveFieldCollisions(table, opts.Collisions)...)
 	}
-	_, err = os.Stat("output/public")
+	return tables, renames, nil
+}
+
+// checkDrift given the [Options] for the run, will infer the project
+// and report how it has drifted from the [Lockfile] of a previous run.
+// It reports whether any breaking changes were found.
+func checkDrift(ctx context.Context, opts Options, w io.Writer) (bool, error) {
+	tables, _, err := inferProject(ctx, opts, nil, opts.tableCache())
 	if err != nil {
-		err := os.Mkdir("output/public", os.ModePerm)
+		return false, err
+	}
+	return reportDrift(opts.Lockfile, tables, w)
+}
+
+// createProjectDirectories will create the necessary project directories under the given root.
+// This is a noop if the directories already exist.
+func createProjectDirectories(root string) error {
+	for _, dir := range []string{"transform", "public"} {
+		err := os.MkdirAll(filepath.Join(root, dir), os.ModePerm)
 		if err != nil {
 			return err
 		}
@@ -81,31 +215,283 @@ func createProjectDirectories() error {
 
 // Main is the entrypoint for the templater.
 // Working in the context of the current working directory as a [fs.FS]
-// and taking [os.Args] as a list of fields to unpack
+// and taking [os.Args] as a list of flags followed by fields to unpack
 // it will generate a the following artifacts:
 //   - A [transform] directory containing the DBT SQL transformations of the tables.
 //   - A [public] directory containing the DBT SQL clone transforms.
 //   - Schemas for source, transform, and public models.
+//   - A lockfile recording the inferred fields of each table.
+//   - Optionally, a report of the primary and foreign keys inferred across the tables.
+//   - Optionally, a profile of the values in each table.
+//   - Optionally, the Snowflake DDL to land the CSV of each table.
+//   - Optionally, the DDL creating the typed target table of each transform model.
+//   - Optionally, a CUE definition of the rows of each table.
+//   - Optionally, the macro or policies used to mask personally identifiable information.
+//
+// When the -diff flag is given, no artifacts are generated. Instead the inferred fields are compared
+// against the lockfile, and a non-zero exit code is returned if there are any breaking changes.
+//
+// When the -watch flag is given, the templater keeps running until interrupted, regenerating the project whenever the CSV's change.
 func Main() int {
-	workingDir, err := os.Getwd()
+	flags := flag.NewFlagSet("templater", flag.ContinueOnError)
+	diff := flags.Bool("diff", false, "report schema drift against the lockfile instead of generating the project")
+	dryRun := flags.Bool("dry-run", false, "print a unified diff of the files generating the project would change, instead of writing them")
+	lockfile := flags.String("lockfile", defaultLockfile, "path of the inference lockfile")
+	tests := flags.String("tests", string(TestsOff), "suggest not_null and unique column tests: off, error, warn or commented")
+	testConfidence := flags.Float64("test-confidence", 1, "fraction of rows that must satisfy a test before it is suggested")
+	testMinRows := flags.Int("test-min-rows", 10, "rows a table must have before tests are suggested for it")
+	dialect := flags.String("dialect", Snowflake.Name, "SQL dialect the identifiers must be valid in: snowflake, postgres or bigquery")
+	identifiers := flags.String("identifiers", string(IdentifierQuote), "make reserved or digit-led column names valid: quote, or prefix with an underscore")
+	nameCase := flags.String("case", string(CaseUpper), "case of the target column names: upper, lower or keep the source case")
+	separator := flags.String("separator", defaultSeparator, "separator between the names of fields nested in JSON")
+	abbreviations := flags.String("abbreviations", "", "abbreviate words in the target column names, as a comma separated list like identifier=ID,number=NUM")
+	maxIdentifierLength := flags.Int("max-identifier-length", 0, "truncate longer target column names, ending them in a hash of the full name")
+	order := flags.String("order", string(OrderAlphabetical), "order of the columns of each model: alphabetical, source, or grouped by the column fields were unpacked from")
+	sample := flags.String("sample", string(SampleAll), "rows read to infer each table: all, first, reservoir or percent")
+	sampleRows := flags.Int("sample-rows", 1000, "rows read when sampling the first rows, or a reservoir of rows")
+	samplePercent := flags.Float64("sample-percent", 10, "percentage of rows read when sampling a percentage of rows")
+	sampleSeed := flags.Int64("sample-seed", 1, "seed for sampling a reservoir or percentage of rows, so the same rows are sampled from run to run")
+	format := flags.String("format", string(OutputDir), "write the generated project to a dir, a zip or tar archive, or stdout as a stream of documents")
+	output := flags.String("output", "", "directory or archive to write the generated project to, defaulting to output, output.zip or output.tar")
+	failOn := flags.String("fail-on", string(SeverityNever), "fail the run if there are diagnostics of this severity or above: info, warning or never")
+	reportPath := flags.String("report", "", "write a JSON report of the run to this file, or - for stdout")
+	workers := flags.Int("workers", 0, "number of tables inferred at once, or 0 for one per CPU")
+	cacheDir := flags.String("cache-dir", "", "directory to cache a summary of what is inferred from each CSV in, so unchanged CSV's aren't inferred again")
+	watch := flags.Bool("watch", false, "keep running, regenerating the project whenever the CSV's change")
+	watchInterval := flags.Duration("watch-interval", time.Second, "how often to check the CSV's for changes when watching")
+	collisions := flags.String("collisions", string(CollisionSuffix), "rename colliding column names: suffix, or qualify with the unpacked column")
+	pii := flags.Bool("pii", false, "detect columns holding personally identifiable information, tagging them in the models")
+	masking := flags.String("mask", string(MaskNone), "mask columns holding personally identifiable information: none, macro or policy")
+	profile := flags.Bool("profile", false, "write a profile of the values in each table to profile.json and profile.md")
+	ddl := flags.Bool("ddl", false, "write the Snowflake DDL to land each CSV in its source schema to the ddl directory")
+	contracts := flags.Bool("contracts", false, "enforce dbt contracts on the transform models, with the data type of each column in the dialect")
+	targetDDL := flags.Bool("target-ddl", false, "write the DDL creating the typed target table of each transform model to the ddl/transform directory")
+	cueSchema := flags.Bool("cue", false, "write the inferred schema of each table as a CUE definition to schema.cue")
+	cueEnums := flags.Int("cue-enums", 10, "make STRING fields with at most this many distinct values enumerations of them in schema.cue")
+	relationships := flags.Bool("relationships", false, "infer primary and foreign keys across tables, suggesting relationships tests")
+	acceptedValues := flags.Int("accepted-values", 0, "suggest accepted_values tests for STRING columns with at most this many distinct values, alongside -tests")
+	err := flags.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		fmt.Println(err)
+		return 1
 	}
-
-	fsys := os.DirFS(workingDir)
-	projectName := filepath.Base(workingDir)
-	unpackFields := os.Args[1:]
-
-	err = createProjectDirectories()
+	testMode, err := ParseTestMode(*tests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	maskingMode, err := ParseMaskingMode(*masking)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	collisionStrategy, err := ParseCollisionStrategy(*collisions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	sqlDialect, err := ParseDialect(*dialect)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	identifierStrategy, err := ParseIdentifierStrategy(*identifiers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	namingCase, err := ParseNameCase(*nameCase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	namingAbbreviations, err := ParseAbbreviations(*abbreviations)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	columnOrder, err := ParseColumnOrder(*order)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	samplingStrategy, err := ParseSamplingStrategy(*sample)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	outputFormat, err := ParseOutputFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	failOnSeverity, err := ParseSeverity(*failOn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if *reportPath == "-" && (*dryRun || *diff || outputFormat == OutputStdout) {
+		fmt.Fprintln(os.Stderr, "-report - can't share stdout with -dry-run, -diff or -format stdout, so needs a file")
+		return 1
+	}
+	if *dryRun && outputFormat != OutputDir {
+		fmt.Fprintf(os.Stderr, "-dry-run compares against the output directory, so can't be used with -format %s\n", outputFormat)
+		return 1
+	}
+	if *watch && (*dryRun || *diff || outputFormat == OutputStdout) {
+		fmt.Fprintln(os.Stderr, "-watch writes the project on each change, so can't be used with -dry-run, -diff or -format stdout")
+		return 1
+	}
+	if *watchInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "-watch-interval must be positive, got %s\n", *watchInterval)
+		return 1
+	}
+	if *acceptedValues > 0 && testMode == TestsOff {
+		fmt.Fprintln(os.Stderr, "-accepted-values suggests tests, so needs -tests error, warn or commented")
+		return 1
+	}
+	if *ddl && sqlDialect.Name != Snowflake.Name {
+		fmt.Fprintf(os.Stderr, "-ddl writes Snowflake DDL, so can't be used with -dialect %s\n", sqlDialect.Name)
+		return 1
+	}
+	sampling := Sampling{Strategy: samplingStrategy, Rows: *sampleRows, Percent: *samplePercent, Seed: *sampleSeed}
+	err = sampling.Validate()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return 1
 	}
 
-	err = generateProject(fsys, projectName, unpackFields...)
+	workingDir, err := os.Getwd()
 	if err != nil {
+		fmt.Println(err)
+	}
+
+	opts := Options{
+		Input:         os.DirFS(workingDir),
+		Project:       filepath.Base(workingDir),
+		UnpackPaths:   flags.Args(),
+		Lockfile:      *lockfile,
+		Relationships: *relationships,
+		Profile:       *profile,
+		DDL:           *ddl,
+		Contracts:     *contracts,
+		TargetDDL:     *targetDDL,
+		CUE:           *cueSchema,
+		CUEEnums:      *cueEnums,
+		PII:           *pii,
+		Masking:       maskingMode,
+		Collisions:    collisionStrategy,
+		Dialect:       sqlDialect,
+		Identifiers:   identifierStrategy,
+		Order:         columnOrder,
+		Sampling:      sampling,
+		Workers:       *workers,
+		FailOn:        failOnSeverity,
+		CacheDir:      *cacheDir,
+		Naming: NamingConvention{
+			Case:          namingCase,
+			Separator:     *separator,
+			Abbreviations: namingAbbreviations,
+			MaxLength:     *maxIdentifierLength,
+		},
+		Tests: TestPolicy{
+			Mode:              testMode,
+			Confidence:        *testConfidence,
+			MinRows:           *testMinRows,
+			MaxAcceptedValues: *acceptedValues,
+		},
+	}
+
+	if *diff {
+		breaking, err := checkDrift(context.Background(), opts, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		if breaking {
+			return 1
+		}
+		return 0
+	}
+
+	fail := func(err error) int {
+		var diagnosticsErr *DiagnosticsError
+		if errors.As(err, &diagnosticsErr) {
+			for _, diagnostic := range diagnosticsErr.Diagnostics {
+				fmt.Fprintln(os.Stderr, diagnostic)
+			}
+		}
 		fmt.Fprintln(os.Stderr, err.Error())
+		if *reportPath != "" {
+			err := writeReport(*reportPath, FailedReport(err))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+		}
 		return 1
 	}
+	if *output == "" {
+		*output = outputFormat.defaultOutput()
+	}
+	if *watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		watchProject(ctx, NewWatcher(opts), *watchInterval, func(result *Result) error {
+			err := writeOutput(result, outputFormat, *output, opts.Lockfile)
+			if err != nil || *reportPath == "" {
+				return err
+			}
+			report := NewReport(result, opts.UnpackPaths)
+			report.Output, report.Lockfile = *output, opts.Lockfile
+			return writeReport(*reportPath, report)
+		}, os.Stderr)
+		return 0
+	}
+	result, err := Generate(context.Background(), opts)
+	if err != nil {
+		return fail(err)
+	}
+	for _, rename := range result.Renames {
+		fmt.Fprintln(os.Stderr, rename)
+	}
+	for _, diagnostic := range result.Diagnostics {
+		fmt.Fprintln(os.Stderr, diagnostic)
+	}
+	report := NewReport(result, opts.UnpackPaths)
+	if *dryRun {
+		changed, err := dryRunProject(result, *output, opts.Lockfile, os.Stdout)
+		if err != nil {
+			return fail(err)
+		}
+		report.Written = []string{}
+		if *reportPath != "" {
+			err = writeReport(*reportPath, report)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+		}
+		if changed {
+			return 1
+		}
+		return 0
+	}
+	err = writeOutput(result, outputFormat, *output, opts.Lockfile)
+	if err != nil {
+		return fail(err)
+	}
+	report.Output, report.Lockfile = *output, opts.Lockfile
+	if outputFormat == OutputStdout {
+		report.Output = "stdout"
+	}
+	if *reportPath != "" {
+		err = writeReport(*reportPath, report)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
 	return 0
 }
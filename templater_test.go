@@ -1,9 +1,17 @@
 package templater_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"sync"
 	"testing"
+	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/rogpeppe/go-internal/testscript"
@@ -491,3 +499,1421 @@ func TestUnpackAttemptsToKeepInferringIfBestCurrentGuessIsVARCHAR(t *testing.T)
 		t.Fatalf("expected 'a' to be inferred as INTEGER, got %s", table.Fields["a"].InferredType)
 	}
 }
+
+func TestDiffLockfiles_ReportsAddedRemovedAndTypeChangedFields(t *testing.T) {
+	t.Parallel()
+	previous := templater.NewLockfile([]*templater.Table{
+		{
+			Name: "BASEBALL",
+			Fields: map[string]templater.Field{
+				"Team": {Path: `"Team"`, Node: "TEAM", InferredType: "STRING"},
+				"Wins": {Path: `"Wins"`, Node: "WINS", InferredType: "INTEGER"},
+			},
+		},
+		{
+			Name: "FREQUENCY",
+			Fields: map[string]templater.Field{
+				"Letter": {Path: `"Letter"`, Node: "LETTER", InferredType: "STRING"},
+			},
+		},
+	})
+	current := templater.NewLockfile([]*templater.Table{
+		{
+			Name: "BASEBALL",
+			Fields: map[string]templater.Field{
+				"Wins":          {Path: `"Wins"`, Node: "WINS", InferredType: "FLOAT"},
+				"Championships": {Path: `"Championships"`, Node: "CHAMPIONSHIPS", InferredType: "INTEGER"},
+			},
+		},
+		{
+			Name: "ENERGY",
+			Fields: map[string]templater.Field{
+				"target": {Path: `"target"`, Node: "TARGET", InferredType: "STRING"},
+			},
+		},
+	})
+	want := []templater.Change{
+		{Kind: templater.FieldAdded, Table: "BASEBALL", Field: `"Championships"`},
+		{Kind: templater.FieldRemoved, Table: "BASEBALL", Field: `"Team"`},
+		{Kind: templater.TypeChanged, Table: "BASEBALL", Field: `"Wins"`, From: "INTEGER", To: "FLOAT"},
+		{Kind: templater.TableAdded, Table: "ENERGY"},
+		{Kind: templater.TableRemoved, Table: "FREQUENCY"},
+	}
+	got := templater.DiffLockfiles(previous, current)
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestChange_BreakingOnlyForRemovalsAndTypeChanges(t *testing.T) {
+	t.Parallel()
+	tc := map[templater.ChangeKind]bool{
+		templater.TableAdded:   false,
+		templater.FieldAdded:   false,
+		templater.TableRemoved: true,
+		templater.FieldRemoved: true,
+		templater.TypeChanged:  true,
+	}
+	for kind, want := range tc {
+		got := templater.Change{Kind: kind}.Breaking()
+		if want != got {
+			t.Errorf("%s: wanted breaking to be %t, got %t", kind, want, got)
+		}
+	}
+}
+
+func TestLockfile_RoundTripsThroughJSON(t *testing.T) {
+	t.Parallel()
+	want := templater.NewLockfile([]*templater.Table{
+		{
+			Name: "BASEBALL",
+			Fields: map[string]templater.Field{
+				"Team": {Path: `"Team"`, Node: "TEAM", InferredType: "STRING"},
+			},
+		},
+	})
+	buf := new(bytes.Buffer)
+	err := want.Write(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := templater.ReadLockfile(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestInferFields_CountsNonNullAndDistinctValues(t *testing.T) {
+	t.Parallel()
+	table := templater.Table{
+		Name:    "TABLE",
+		Project: "PROJECT",
+		Fields:  make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[
+		{ a: 1, b: "x", c: null },
+		{ a: 2, b: "",  c: null },
+		{ a: 3, b: "x", c: null },
+	]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Rows != 3 {
+		t.Fatalf("expected 3 rows, got %d", table.Rows)
+	}
+	tc := []struct {
+		Field    string
+		Nulls    int
+		Distinct int
+	}{
+		{Field: "a", Nulls: 0, Distinct: 3},
+		{Field: "b", Nulls: 1, Distinct: 1},
+		{Field: "c", Nulls: 3, Distinct: 0},
+	}
+	for _, c := range tc {
+		stats := table.Fields[c.Field].Stats
+		if nulls := stats.Nulls(table.Rows); nulls != c.Nulls {
+			t.Errorf("%s: wanted %d nulls, got %d", c.Field, c.Nulls, nulls)
+		}
+		if distinct, _ := stats.Distinct(); distinct != c.Distinct {
+			t.Errorf("%s: wanted %d distinct values, got %d", c.Field, c.Distinct, distinct)
+		}
+	}
+}
+
+func TestGenerate_EstimatesDistinctValuesBeyondTheExactLimit(t *testing.T) {
+	t.Parallel()
+	csv := new(bytes.Buffer)
+	csv.WriteString("id,kind\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(csv, "%d,k%d\n", i, i%3)
+	}
+	input := fstest.MapFS{"EVENTS.csv": {Data: csv.Bytes()}}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := result.Tables[0].Fields
+	distinct, exact := fields["id"].Stats.Distinct()
+	if exact || distinct < 19600 || distinct > 20400 {
+		t.Errorf("want an estimate of about 20000 distinct ids, got %d (exact %t)", distinct, exact)
+	}
+	distinct, exact = fields["kind"].Stats.Distinct()
+	if !exact || distinct != 3 {
+		t.Errorf("want exactly 3 distinct kinds, got %d (exact %t)", distinct, exact)
+	}
+}
+
+func TestGenerateProjectModel_SuggestsTestsSupportedByObservedValues(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name:    "TABLE",
+		Project: "PROJECT",
+		Fields:  make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[
+		{ id: 1, team: "Reds", nickname: null },
+		{ id: 2, team: "Reds", nickname: "Big Red" },
+	]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := templater.TestPolicy{Mode: templater.TestsError, Confidence: 1}
+	got := templater.GenerateProjectModel([]*templater.Table{table}, templater.WithColumnTests(policy))
+	want := templater.Models{
+		Version: 2,
+		Models: []templater.Model{
+			{
+				Name: "TABLE",
+				Columns: []templater.Column{
+					{Name: "ID", Tests: []any{"not_null", "unique"}},
+					{Name: "NICKNAME", Tests: []any{"unique"}},
+					{Name: "TEAM", Tests: []any{"not_null"}},
+				},
+			},
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+
+	policy.MinRows = 3
+	got = templater.GenerateProjectModel([]*templater.Table{table}, templater.WithColumnTests(policy))
+	for _, column := range got.Models[0].Columns {
+		if column.Tests != nil {
+			t.Errorf("%s: expected no tests below the minimum rows, got %v", column.Name, column.Tests)
+		}
+	}
+}
+
+func TestGenerateProjectModel_SuggestsAcceptedValuesBelowCardinalityThreshold(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name:    "TABLE",
+		Project: "PROJECT",
+		Fields:  make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[
+		{ target: "Liquid", source: "Bio-conversion", count: 1 },
+		{ target: "Solid",  source: "BiofuelImports", count: 1 },
+		{ target: "Liquid", source: "Coal imports",   count: 2 },
+	]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A confidence above 1 can never be met, leaving only the accepted_values tests.
+	policy := templater.TestPolicy{Mode: templater.TestsWarn, Confidence: 2, MaxAcceptedValues: 2}
+	got := templater.GenerateProjectModel([]*templater.Table{table}, templater.WithColumnTests(policy))
+	want := templater.Models{
+		Version: 2,
+		Models: []templater.Model{
+			{
+				Name: "TABLE",
+				Columns: []templater.Column{
+					{Name: "COUNT"},
+					{Name: "SOURCE"},
+					{Name: "TARGET", Tests: []any{
+						map[string]any{"accepted_values": map[string]any{
+							"values": []string{"Liquid", "Solid"},
+							"config": map[string]any{"severity": "warn"},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestInferEntityGraph_DetectsPrimaryAndForeignKeysAcrossTables(t *testing.T) {
+	t.Parallel()
+	customers := &templater.Table{
+		Name:   "CUSTOMER",
+		Fields: make(map[string]templater.Field),
+	}
+	orders := &templater.Table{
+		Name:   "ORDERS",
+		Fields: make(map[string]templater.Field),
+	}
+	for table, literal := range map[*templater.Table]string{
+		customers: `[{ id: 1, name: "Ada" }, { id: 2, name: "Grace" }]`,
+		orders:    `[{ id: 1, customer_id: 2 }, { id: 2, customer_id: 2 }, { id: 3, customer_id: 2 }]`,
+	} {
+		iter, err := createCueValue(t, literal).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = table.InferFields(iter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := templater.EntityGraph{
+		PrimaryKeys: []templater.Key{
+			{Table: "CUSTOMER", Column: "ID"},
+			{Table: "ORDERS", Column: "ID"},
+		},
+		Relationships: []templater.Relationship{
+			{
+				From: templater.Key{Table: "ORDERS", Column: "CUSTOMER_ID"},
+				To:   templater.Key{Table: "CUSTOMER", Column: "ID"},
+			},
+		},
+	}
+	got := templater.InferEntityGraph([]*templater.Table{customers, orders})
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestInferEntityGraph_IgnoresForeignKeysWithValuesMissingFromPrimaryKey(t *testing.T) {
+	t.Parallel()
+	customers := &templater.Table{
+		Name:   "CUSTOMER",
+		Fields: make(map[string]templater.Field),
+	}
+	orders := &templater.Table{
+		Name:   "ORDERS",
+		Fields: make(map[string]templater.Field),
+	}
+	for table, literal := range map[*templater.Table]string{
+		customers: `[{ id: 1 }, { id: 2 }]`,
+		orders:    `[{ id: 1, customer_id: 2 }, { id: 2, customer_id: 3 }]`,
+	} {
+		iter, err := createCueValue(t, literal).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = table.InferFields(iter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := templater.InferEntityGraph([]*templater.Table{customers, orders})
+	if len(got.Relationships) != 0 {
+		t.Fatalf("expected no relationships, got %v", got.Relationships)
+	}
+}
+
+func TestNewProfile_SummarisesObservedValues(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name:   "TABLE",
+		Fields: make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[
+		{ a: 1,   b: "x" },
+		{ a: 4,   b: "xyz" },
+		{ a: "?", b: "xyz" },
+		{ a: null },
+	]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mean := 2.5
+	want := templater.Profile{
+		Tables: []templater.TableProfile{
+			{
+				Name: "TABLE",
+				Rows: 4,
+				Fields: []templater.FieldProfile{
+					{
+						Path:          `"a"`,
+						Node:          "A",
+						InferredType:  "INTEGER",
+						Nulls:         1,
+						NullPercent:   25,
+						Distinct:      3,
+						DistinctExact: true,
+						Min:           1.0,
+						Max:           4.0,
+						Mean:          &mean,
+						Lengths: &templater.LengthProfile{
+							Min:     1,
+							Max:     1,
+							Mean:    1,
+							Buckets: []templater.LengthBucket{{MaxLength: 1, Count: 1}},
+						},
+						TopValues:     []templater.ValueCount{{Value: "1", Count: 1}, {Value: "4", Count: 1}, {Value: "?", Count: 1}},
+						TypeConflicts: 1,
+					},
+					{
+						Path:          `"b"`,
+						Node:          "B",
+						InferredType:  "STRING",
+						Nulls:         1,
+						NullPercent:   25,
+						Distinct:      2,
+						DistinctExact: true,
+						Min:           "x",
+						Max:           "xyz",
+						Lengths: &templater.LengthProfile{
+							Min:     1,
+							Max:     3,
+							Mean:    7.0 / 3,
+							Buckets: []templater.LengthBucket{{MaxLength: 1, Count: 1}, {MaxLength: 4, Count: 2}},
+						},
+						TopValues: []templater.ValueCount{{Value: "xyz", Count: 2}, {Value: "x", Count: 1}},
+					},
+				},
+			},
+		},
+	}
+	got := templater.NewProfile([]*templater.Table{table})
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestDetectPII_ClassifiesFieldsByValuesAndNames(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name:   "TABLE",
+		Fields: make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[
+		{ contact: "ada@example.com", card: "4111 1111 1111 1111", seen_from: "10.0.0.1", ssn: "078-05-1120", tel: "+61 400 123 456", last_name: "Lovelace", plan: "Gold", id: 4111111111111111 },
+		{ contact: "grace@example.org", card: "5500-0000-0000-0004", seen_from: "::1", ssn: "219-09-9999", tel: "(02) 9876 5432", last_name: "Hopper", plan: "Silver", id: 2 },
+	]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templater.DetectPII([]*templater.Table{table})
+	want := map[string]templater.PIICategory{
+		"contact":   templater.PIIEmail,
+		"card":      templater.PIICreditCard,
+		"seen_from": templater.PIIIPAddress,
+		"ssn":       templater.PIINationalID,
+		"tel":       templater.PIIPhone,
+		"last_name": templater.PIIName,
+		"plan":      "",
+		"id":        "",
+	}
+	for path, category := range want {
+		if got := table.Fields[path].PII; got != category {
+			t.Errorf("%s: wanted PII category %q, got %q", path, category, got)
+		}
+	}
+}
+
+func TestGenerate_LeavesTheValuesOfPIIFieldsOutOfTheProfileAndTests(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"CUSTOMERS.csv": {Data: []byte("id,email,plan\n1,ada@example.com,gold\n2,bob@example.com,gold\n3,ada@example.com,silver\n")},
+	}
+	opts := templater.Options{
+		Input:    input,
+		Project:  "SHOP",
+		PII:      true,
+		Profile:  true,
+		Tests:    templater.TestPolicy{Mode: templater.TestsError, Confidence: 1, MaxAcceptedValues: 5},
+		CUE:      true,
+		CUEEnums: 5,
+	}
+	result, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"profile.json", "profile.md", "transform/_models_schema.yml", "schema.cue"} {
+		if bytes.Contains(result.Artifacts[path], []byte("example.com")) {
+			t.Errorf("%s: want no emails, got %s", path, result.Artifacts[path])
+		}
+	}
+	if !bytes.Contains(result.Artifacts["transform/_models_schema.yml"], []byte("- gold")) {
+		t.Errorf("want accepted_values tests for the other fields, got %s", result.Artifacts["transform/_models_schema.yml"])
+	}
+	if !bytes.Contains(result.Artifacts["profile.md"], []byte("| EMAIL | STRING | 0.0 | 2 | *masked* | *masked* |  | 15-15 (mean 15.0) | *masked* | 0 |")) {
+		t.Errorf("want the values of EMAIL masked, got %s", result.Artifacts["profile.md"])
+	}
+}
+
+func TestGenerateColumnsSQL_WrapsPIIColumnsInMaskingMacro(t *testing.T) {
+	t.Parallel()
+	fields := map[string]templater.Field{
+		"Email": {
+			Path:         "Email",
+			Node:         "Email",
+			InferredType: "STRING",
+			PII:          templater.PIIEmail,
+		},
+		"Wins": {
+			Path:         "Wins",
+			Node:         "Wins",
+			InferredType: "INTEGER",
+		},
+	}
+	got := templater.GenerateColumnsSQL(fields, templater.WithMasking(templater.MaskMacro))
+	want := `  {{ mask_pii('"Email"::STRING', 'email') }} AS EMAIL
+  ,"Wins"::INTEGER AS WINS`
+	if want != got {
+		t.Fatalf(cmp.Diff(want, got))
+	}
+}
+
+func TestResolveFieldCollisions_SuffixesCollidingTargetColumns(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name: "TABLE",
+		Fields: map[string]templater.Field{
+			"fooBar":     {Path: `"fooBar"`, Node: "FOO_BAR"},
+			"foo_bar":    {Path: `"foo_bar"`, Node: "FOO_BAR"},
+			"FOO_BAR_2":  {Path: `"FOO_BAR_2"`, Node: "FOO_BAR_2"},
+			"V:foo_bar":  {Path: `"V":"foo_bar"`, Node: "FOO_BAR"},
+			"unaffected": {Path: `"unaffected"`, Node: "UNAFFECTED"},
+		},
+	}
+	got := templater.ResolveFieldCollisions(table, templater.CollisionSuffix)
+	want := []templater.Rename{
+		{Table: "TABLE", Path: `"foo_bar"`, From: "FOO_BAR", To: "FOO_BAR_3"},
+		{Table: "TABLE", Path: `"V":"foo_bar"`, From: "FOO_BAR", To: "FOO_BAR_4"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+	if table.Fields["fooBar"].Node != "FOO_BAR" {
+		t.Errorf("expected fooBar to keep its name, got %s", table.Fields["fooBar"].Node)
+	}
+}
+
+func TestGenerate_KeepsSuffixedNamesWithinTheMaxLength(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("a very long column name,A_VERY_LONG_COLUMN_NAME\n1,2\n")},
+	}
+	opts := templater.Options{Input: input, Project: "SHOP", Naming: templater.NamingConvention{MaxLength: 10}}
+	result, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Renames) != 1 {
+		t.Fatalf("want one rename, got %v", result.Renames)
+	}
+	rename := result.Renames[0]
+	if len(rename.To) > 10 {
+		t.Errorf("want the suffixed name within 10 characters, got %s", rename.To)
+	}
+	model := result.Artifacts["transform/TRANS01_ORDERS.sql"]
+	for _, name := range []string{rename.From, rename.To} {
+		if !bytes.Contains(model, []byte("AS "+name+"\n")) {
+			t.Errorf("want a column aliased %s, got %s", name, model)
+		}
+	}
+}
+
+func TestGenerate_ResolvesCollisionsBetweenPrefixedIdentifiers(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("order,_order,2022_sales\n1,2,3\n")},
+	}
+	for _, dialect := range []templater.Dialect{{}, templater.Snowflake} {
+		opts := templater.Options{Input: input, Project: "SHOP", Dialect: dialect, Identifiers: templater.IdentifierPrefix}
+		result, err := templater.Generate(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []templater.Rename{{Table: "ORDERS", Path: `"order"`, From: "_ORDER", To: "_ORDER_2"}}
+		if !cmp.Equal(want, result.Renames) {
+			t.Fatalf("dialect %q: %s", dialect.Name, cmp.Diff(want, result.Renames))
+		}
+		model := result.Artifacts["transform/TRANS01_ORDERS.sql"]
+		for _, column := range []string{`"_order"::INTEGER AS _ORDER`, `"order"::INTEGER AS _ORDER_2`, `"2022_sales"::INTEGER AS _2022_SALES`} {
+			if !bytes.Contains(model, []byte(column+"\n")) {
+				t.Errorf("dialect %q: want %s, got %s", dialect.Name, column, model)
+			}
+		}
+	}
+}
+
+func TestResolveFieldCollisions_QualifiesUnpackedFields(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name: "TABLE",
+		Fields: map[string]templater.Field{
+			"V:attributes.active": {Path: `"V":"attributes"."active"`, Node: "ATTRIBUTES__ACTIVE"},
+			"W:attributes.active": {Path: `"W":"attributes"."active"`, Node: "ATTRIBUTES__ACTIVE"},
+		},
+	}
+	got := templater.ResolveFieldCollisions(table, templater.CollisionQualify)
+	want := []templater.Rename{
+		{Table: "TABLE", Path: `"W":"attributes"."active"`, From: "ATTRIBUTES__ACTIVE", To: "W__ATTRIBUTES__ACTIVE"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestResolveTableCollisions_SuffixesLaterTables(t *testing.T) {
+	t.Parallel()
+	tables := []*templater.Table{
+		{Name: "ORDERS", File: "ORDERS.csv"},
+		{Name: "ORDERS", File: "orders.csv"},
+		{Name: "ORDERS", File: "Orders.csv"},
+	}
+	templater.ResolveTableCollisions(tables)
+	for i, want := range []string{"ORDERS", "ORDERS_2", "ORDERS_3"} {
+		if tables[i].Name != want {
+			t.Errorf("%s: wanted %s, got %s", tables[i].File, want, tables[i].Name)
+		}
+	}
+}
+
+func TestDialect_NeedsQuotingReservedWordsAndLeadingDigits(t *testing.T) {
+	t.Parallel()
+	cases := map[string]bool{
+		"ORDER":      true,
+		"order":      true,
+		"2022_SALES": true,
+		"_2022":      false,
+		"CUSTOMER":   false,
+		"":           true,
+	}
+	for identifier, want := range cases {
+		got := templater.Snowflake.NeedsQuoting(identifier)
+		if got != want {
+			t.Errorf("NeedsQuoting(%q): want %v, got %v", identifier, want, got)
+		}
+	}
+	if templater.Snowflake.NeedsQuoting("LIMIT") {
+		t.Error("LIMIT is not reserved in Snowflake")
+	}
+	if !templater.Postgres.NeedsQuoting("LIMIT") {
+		t.Error("LIMIT is reserved in Postgres")
+	}
+}
+
+func TestDialect_SafeIdentifierQuotesOrPrefixes(t *testing.T) {
+	t.Parallel()
+	got, quoted := templater.Snowflake.SafeIdentifier("GROUP", templater.IdentifierQuote)
+	if got != "GROUP" || !quoted {
+		t.Errorf("want GROUP to be quoted, got %q (quoted: %v)", got, quoted)
+	}
+	got, quoted = templater.Snowflake.SafeIdentifier("2022_SALES", templater.IdentifierPrefix)
+	if got != "_2022_SALES" || quoted {
+		t.Errorf("want _2022_SALES unquoted, got %q (quoted: %v)", got, quoted)
+	}
+	if got := templater.BigQuery.Quote("GROUP"); got != "`GROUP`" {
+		t.Errorf("want BigQuery to quote with backticks, got %s", got)
+	}
+}
+
+func TestGenerateColumnsSQL_QuotesReservedAndDigitLedColumns(t *testing.T) {
+	t.Parallel()
+	fields := map[string]templater.Field{
+		"order":      {Path: `"order"`, Node: "order", InferredType: "INTEGER"},
+		"2022 sales": {Path: `"2022 sales"`, Node: "2022 sales", InferredType: "FLOAT"},
+	}
+	want := "  \"2022 sales\"::FLOAT AS \"2022_SALES\"\n  ,\"order\"::INTEGER AS \"ORDER\""
+	got := templater.GenerateColumnsSQL(fields)
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+	want = "  \"2022 sales\"::FLOAT AS _2022_SALES\n  ,\"order\"::INTEGER AS _ORDER"
+	got = templater.GenerateColumnsSQL(fields, templater.WithDialect(templater.Snowflake, templater.IdentifierPrefix))
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestInferFields_NamesNodesByTableNamingConvention(t *testing.T) {
+	t.Parallel()
+	table := templater.Table{
+		Name:    "TABLE",
+		Project: "PROJECT",
+		Fields:  make(map[string]templater.Field),
+		Naming:  templater.NamingConvention{Case: templater.CaseLower},
+	}
+	v := createCueValue(t, `[{ "customerId": 1,}]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Fields["customerId"].Node != "customer_id" {
+		t.Fatalf("expected customerId to be named customer_id, got %s", table.Fields["customerId"].Node)
+	}
+}
+
+func TestNamingConvention_NamesFollowConvention(t *testing.T) {
+	t.Parallel()
+	abbreviations := map[string]string{"identifier": "ID", "number": "NUM"}
+	cases := []struct {
+		naming templater.NamingConvention
+		key    string
+		want   string
+	}{
+		{naming: templater.NamingConvention{}, key: "attributes.onHand", want: "ATTRIBUTES__ON_HAND"},
+		{naming: templater.NamingConvention{Case: templater.CaseLower}, key: "attributes.onHand", want: "attributes__on_hand"},
+		{naming: templater.NamingConvention{Case: templater.CaseKeep}, key: "attributes.onHand", want: "attributes__onHand"},
+		{naming: templater.NamingConvention{Separator: "_"}, key: "attributes.onHand", want: "ATTRIBUTES_ON_HAND"},
+		{naming: templater.NamingConvention{Abbreviations: abbreviations}, key: "customerIdentifier", want: "CUSTOMER_ID"},
+		{naming: templater.NamingConvention{Case: templater.CaseLower, Abbreviations: abbreviations}, key: "phone Number", want: "phone_num"},
+		{naming: templater.NamingConvention{MaxLength: 20}, key: "a very long name of a field", want: "A_VERY_LONG_30E2E2F5"},
+	}
+	for _, tc := range cases {
+		got := tc.naming.Name(tc.key)
+		if got != tc.want {
+			t.Errorf("%+v: want %s named %s, got %s", tc.naming, tc.key, tc.want, got)
+		}
+		if again := tc.naming.Name(got); again != got {
+			t.Errorf("%+v: want naming to be idempotent, but %s was renamed %s", tc.naming, got, again)
+		}
+	}
+}
+
+func TestNamingConvention_TruncatedNamesStayDistinct(t *testing.T) {
+	t.Parallel()
+	naming := templater.NamingConvention{MaxLength: 16}
+	a := naming.Name("a long name of the first field")
+	b := naming.Name("a long name of the first fields")
+	if len(a) > 16 || len(b) > 16 {
+		t.Fatalf("expected names truncated to at most 16 characters, got %s and %s", a, b)
+	}
+	if a == b {
+		t.Fatalf("expected truncated names to differ, got %s for both", a)
+	}
+}
+
+func TestParseAbbreviations_RejectsPairsWithoutAbbreviation(t *testing.T) {
+	t.Parallel()
+	got, err := templater.ParseAbbreviations("identifier=ID, number=NUM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"identifier": "ID", "number": "NUM"}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+	_, err = templater.ParseAbbreviations("identifier")
+	if err == nil {
+		t.Fatal("expected an error for a word without an abbreviation")
+	}
+}
+
+func TestInferFields_RecordsOrdinalOfSourceColumn(t *testing.T) {
+	t.Parallel()
+	table := templater.Table{
+		Name:    "TABLE",
+		Project: "PROJECT",
+		Fields:  make(map[string]templater.Field),
+	}
+	v := createCueValue(t, `[{ "zeta": 1, "V": "{\"b\": 1}", "alpha": "x"}, { "zeta": 2, "V": "{\"a\": 2}", "alpha": "y"}]`)
+	iter, err := v.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = table.InferFields(iter, "V")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"zeta": 0, "V:b": 1, "V:a": 1, "alpha": 2}
+	got := map[string]int{}
+	for key, field := range table.Fields {
+		got[key] = field.Ordinal
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerateColumnsSQL_SortsColumnsInColumnOrder(t *testing.T) {
+	t.Parallel()
+	fields := map[string]templater.Field{
+		"zeta":  {Path: `"zeta"`, Node: "ZETA", InferredType: "INTEGER", Ordinal: 0},
+		"V:b":   {Path: `"V":"b"`, Node: "B", InferredType: "INTEGER", Ordinal: 1},
+		"V:a":   {Path: `"V":"a"`, Node: "A", InferredType: "INTEGER", Ordinal: 1},
+		"alpha": {Path: `"alpha"`, Node: "ALPHA", InferredType: "STRING", Ordinal: 2},
+	}
+	want := "  \"zeta\"::INTEGER AS ZETA\n  ,\"V\":\"a\"::INTEGER AS A\n  ,\"V\":\"b\"::INTEGER AS B\n  ,\"alpha\"::STRING AS ALPHA"
+	got := templater.GenerateColumnsSQL(fields, templater.WithColumnOrder(templater.OrderGrouped))
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+	want = "  \"V\":\"a\"::INTEGER AS A\n  ,\"alpha\"::STRING AS ALPHA\n  ,\"V\":\"b\"::INTEGER AS B\n  ,\"zeta\"::INTEGER AS ZETA"
+	got = templater.GenerateColumnsSQL(fields)
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestResolveTableCollisions_KeepsTheSourceTableOfRenamedTables(t *testing.T) {
+	t.Parallel()
+	tables := []*templater.Table{
+		{Name: "SALES_ORDERS", Source: "SALES", SourceTable: "ORDERS", File: "SALES/ORDERS.csv"},
+		{Name: "SALES_ORDERS", Source: "SALES", SourceTable: "ORDERS", File: "SALES/orders.csv"},
+		{Name: "SALES_ORDERS", Project: "PROJECT", File: "SALES_ORDERS.csv"},
+		{Name: "SALES_ORDERS", Project: "PROJECT", File: "sales_orders.csv"},
+	}
+	templater.ResolveTableCollisions(tables)
+	want := [][2]string{{"SALES_ORDERS", "ORDERS"}, {"SALES_ORDERS_2", "ORDERS"}, {"SALES_ORDERS_3", "SALES_ORDERS"}, {"SALES_ORDERS_4", "SALES_ORDERS"}}
+	for i, want := range want {
+		got := [2]string{tables[i].Name, tables[i].SourceTable}
+		if got != want {
+			t.Errorf("%s: wanted %v, got %v", tables[i].File, want, got)
+		}
+	}
+}
+
+func TestInferRow_InfersTheSameFieldsAsInferFields(t *testing.T) {
+	t.Parallel()
+	rows := `[{ "a": 1, "b": "x"}, { "a": 2.5, "b": null, "c": true}]`
+	batch := templater.Table{Fields: make(map[string]templater.Field)}
+	iter, err := createCueValue(t, rows).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = batch.InferFields(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := templater.Table{}
+	iter, err = createCueValue(t, rows).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for iter.Next() {
+		err = streamed.InferRow(iter.Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if batch.Rows != streamed.Rows {
+		t.Fatalf("expected %d rows, got %d", batch.Rows, streamed.Rows)
+	}
+	for key, want := range batch.Fields {
+		got := streamed.Fields[key]
+		if want.InferredType != got.InferredType || want.Node != got.Node || want.Ordinal != got.Ordinal {
+			t.Errorf("%s: want %+v, got %+v", key, want, got)
+		}
+	}
+}
+
+func TestSampling_ValidatesWhatItsStrategyNeeds(t *testing.T) {
+	t.Parallel()
+	valid := []templater.Sampling{
+		{},
+		{Strategy: templater.SampleFirst, Rows: 10},
+		{Strategy: templater.SampleReservoir, Rows: 10, Seed: 3},
+		{Strategy: templater.SamplePercent, Percent: 100},
+	}
+	for _, sampling := range valid {
+		if err := sampling.Validate(); err != nil {
+			t.Errorf("%s: unexpected error %v", sampling, err)
+		}
+	}
+	invalid := []templater.Sampling{
+		{Strategy: templater.SampleFirst},
+		{Strategy: templater.SampleReservoir, Rows: -1},
+		{Strategy: templater.SamplePercent, Percent: 101},
+	}
+	for _, sampling := range invalid {
+		if err := sampling.Validate(); err == nil {
+			t.Errorf("%s: expected an error", sampling)
+		}
+	}
+}
+
+func TestGenerate_RendersTheProjectInMemory(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv":       {Data: []byte("id,total\n1,9.5\n")},
+		"SALES/REGION.csv": {Data: []byte("id,region\n1,north\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"_source_schema.yml",
+		"profile.json",
+		"profile.md",
+		"public/ORDERS.sql",
+		"public/SALES/SALES_REGION.sql",
+		"public/_models_schema.yml",
+		"transform/SALES/TRANS01_SALES_REGION.sql",
+		"transform/TRANS01_ORDERS.sql",
+		"transform/_models_schema.yml",
+	}
+	if !cmp.Equal(want, result.Artifacts.Paths()) {
+		t.Fatal(cmp.Diff(want, result.Artifacts.Paths()))
+	}
+	if len(result.Tables) != 2 || len(result.Lockfile.Tables) != 2 {
+		t.Fatalf("want 2 tables inferred and locked, got %d and %d", len(result.Tables), len(result.Lockfile.Tables))
+	}
+	got := result.Artifacts["transform/TRANS01_ORDERS.sql"]
+	if !bytes.Contains(got, []byte(`"total"::FLOAT AS TOTAL`)) {
+		t.Fatalf("want the TOTAL column cast to FLOAT, got %s", got)
+	}
+}
+
+func TestGenerate_StopsOnceTheContextIsDone(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("id,total\n1,9.5\n")},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := templater.Generate(ctx, templater.Options{Input: input, Project: "SHOP"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestGenerate_CollectsTheErrorsOfEveryFailingTable(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"A.csv": {Data: []byte("")},
+		"B.csv": {Data: []byte("id\n1\n")},
+		"C.csv": {Data: []byte("")},
+	}
+	_, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", Workers: 3})
+	var failed templater.TableErrors
+	if !errors.As(err, &failed) {
+		t.Fatalf("want TableErrors, got %v", err)
+	}
+	want := "A.csv: empty CSV\nC.csv: empty CSV"
+	if err.Error() != want {
+		t.Fatalf("want %q, got %q", want, err.Error())
+	}
+}
+
+func TestGenerate_WarnsAboutCSVsWithNoRowsAfterTheHeader(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("id,2022 sales\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []templater.Diagnostic{{
+		Severity: templater.SeverityWarning,
+		Code:     templater.EmptyCSV,
+		File:     "ORDERS.csv",
+		Message:  "no rows after the header, so every column fell back to VARCHAR",
+		Count:    1,
+	}}
+	if !cmp.Equal(want, result.Diagnostics) {
+		t.Fatal(cmp.Diff(want, result.Diagnostics))
+	}
+	model := result.Artifacts["transform/TRANS01_ORDERS.sql"]
+	for _, column := range []string{`"id"::VARCHAR AS ID`, `"2022 sales"::VARCHAR AS "2022_SALES"`} {
+		if !bytes.Contains(model, []byte(column)) {
+			t.Errorf("want %s, got %s", column, model)
+		}
+	}
+	_, err = templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", FailOn: templater.SeverityWarning})
+	if err == nil {
+		t.Fatal("want the warning to fail the run at -fail-on warning")
+	}
+}
+
+func TestArtifacts_CopyToStreamSinkWritesOneDocumentPerFile(t *testing.T) {
+	t.Parallel()
+	artifacts := templater.Artifacts{
+		"transform/A.sql": []byte("SELECT 1"),
+		"_schema.yml":     []byte("version: 2\n"),
+	}
+	buf := new(bytes.Buffer)
+	err := artifacts.CopyTo(templater.NewStreamSink(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- # _schema.yml\nversion: 2\n--- # transform/A.sql\nSELECT 1\n"
+	if buf.String() != want {
+		t.Fatal(cmp.Diff(want, buf.String()))
+	}
+}
+
+func TestZipSink_WritesEachFileToTheArchive(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	sink := templater.NewZipSink(buf)
+	err := templater.Artifacts{"transform/A.sql": []byte("SELECT 1")}.CopyTo(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "transform/A.sql" {
+		t.Fatalf("want only transform/A.sql in the archive, got %v", archive.File)
+	}
+	f, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "SELECT 1" {
+		t.Fatalf("want SELECT 1, got %q", got)
+	}
+}
+
+func TestDiffArtifacts_ReportsNewModifiedAndDeletedGeneratedFiles(t *testing.T) {
+	t.Parallel()
+	output := fstest.MapFS{
+		"same.sql":            {Data: []byte("SELECT 1\n")},
+		"changed.sql":         {Data: []byte("SELECT 1\n")},
+		"stale.sql":           {Data: []byte("SELECT 1\n")},
+		"hand_written.sql":    {Data: []byte("SELECT 1\n")},
+		"templater.lock.json": {Data: []byte("{}\n")},
+	}
+	artifacts := templater.Artifacts{
+		"same.sql":    []byte("SELECT 1\n"),
+		"changed.sql": []byte("SELECT 2\n"),
+		"added.sql":   []byte("SELECT 3\n"),
+	}
+	changes, err := templater.DiffArtifacts(output, artifacts, "same.sql", "changed.sql", "stale.sql", "removed.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []templater.FileChange{
+		{Path: "added.sql", Status: templater.FileNew, After: []byte("SELECT 3\n")},
+		{Path: "changed.sql", Status: templater.FileModified, Before: []byte("SELECT 1\n"), After: []byte("SELECT 2\n")},
+		{Path: "stale.sql", Status: templater.FileDeleted, Before: []byte("SELECT 1\n")},
+	}
+	if !cmp.Equal(want, changes) {
+		t.Fatal(cmp.Diff(want, changes))
+	}
+}
+
+func TestNewReport_WarnsOfCollisionsAndTypeConflicts(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("fooBar,foo_bar,payload\n1,2,\"{\"\"v\"\": \"\"x\"\"}\"\n3,4,\"{\"\"v\"\": 1}\"\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", UnpackPaths: []string{"payload"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := templater.NewReport(result, []string{"payload"})
+	want := []templater.Warning{
+		{Kind: templater.WarningCollision, File: "ORDERS.csv", Table: "ORDERS", Path: `"foo_bar"`, Message: `ORDERS: "foo_bar" renamed from FOO_BAR to FOO_BAR_2 to avoid a collision`},
+		{Kind: templater.WarningTypeConflict, File: "ORDERS.csv", Table: "ORDERS", Path: `"payload":"v"`, Message: "1 of 2 values are not STRING"},
+	}
+	if !cmp.Equal(want, report.Warnings) {
+		t.Fatal(cmp.Diff(want, report.Warnings))
+	}
+	if report.Status != "ok" || len(report.Files) != 1 || report.Files[0].Rows != 2 {
+		t.Fatalf("want an ok report of ORDERS.csv with 2 rows, got %+v", report)
+	}
+}
+
+func TestFailedReport_AttributesErrorsToTheirFiles(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"A.csv": {Data: []byte("")},
+		"B.csv": {Data: []byte("id\n1\n")},
+	}
+	_, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP"})
+	report := templater.FailedReport(err)
+	want := []templater.ReportError{{File: "A.csv", Message: "empty CSV"}}
+	if !cmp.Equal(want, report.Errors) {
+		t.Fatal(cmp.Diff(want, report.Errors))
+	}
+}
+
+func TestDiagnostics_CountsRepeatsAndListsInOrder(t *testing.T) {
+	t.Parallel()
+	diagnostics := &templater.Diagnostics{}
+	diagnostics.Add(templater.Diagnostic{Severity: templater.SeverityInfo, Code: templater.NullUnpackValue, File: "B.csv", Line: 3, Path: "v"})
+	diagnostics.Add(templater.Diagnostic{Severity: templater.SeverityWarning, Code: templater.InvalidUnpackJSON, File: "A.csv", Line: 9, Path: "v"})
+	diagnostics.Add(templater.Diagnostic{Severity: templater.SeverityInfo, Code: templater.NullUnpackValue, File: "B.csv", Line: 7, Path: "v"})
+	want := []templater.Diagnostic{
+		{Severity: templater.SeverityWarning, Code: templater.InvalidUnpackJSON, File: "A.csv", Line: 9, Path: "v", Count: 1},
+		{Severity: templater.SeverityInfo, Code: templater.NullUnpackValue, File: "B.csv", Line: 3, Path: "v", Count: 2},
+	}
+	got := diagnostics.List()
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerate_FailsAtTheChosenSeverity(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("id,nickname\n1,NA\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", FailOn: templater.SeverityNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []templater.Diagnostic{{
+		Severity: templater.SeverityWarning,
+		Code:     templater.AllNull,
+		File:     "ORDERS.csv",
+		Path:     `"nickname"`,
+		Message:  "every value was null, so the type fell back to VARCHAR",
+		Count:    1,
+	}}
+	if !cmp.Equal(want, result.Diagnostics) {
+		t.Fatal(cmp.Diff(want, result.Diagnostics))
+	}
+	_, err = templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", FailOn: templater.SeverityWarning})
+	var diagnosticsErr *templater.DiagnosticsError
+	if !errors.As(err, &diagnosticsErr) || !cmp.Equal(want, diagnosticsErr.Diagnostics) {
+		t.Fatalf("want a DiagnosticsError of the all null field, got %v", err)
+	}
+}
+
+func TestWatcher_InfersOnlyTheTablesWhoseFilesChanged(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv":    {Data: []byte("id,total\n1,9\n")},
+		"CUSTOMERS.csv": {Data: []byte("id,nickname\n1,NA\n")},
+	}
+	watcher := templater.NewWatcher(templater.Options{Input: input, Project: "SHOP"})
+	update, err := watcher.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"CUSTOMERS.csv", "ORDERS.csv"}; !cmp.Equal(want, update.Inferred) {
+		t.Fatal(cmp.Diff(want, update.Inferred))
+	}
+	update, err = watcher.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update != nil {
+		t.Fatalf("want no update while nothing changed, got %v", update)
+	}
+
+	input["ORDERS.csv"] = &fstest.MapFile{Data: []byte("id,total\n1,9.5\n")}
+	update, err = watcher.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "modified ORDERS.csv\ninferred 1 of 2 tables, 1 diagnostics: 0 new, 1 modified, 0 deleted files"
+	if update.String() != want {
+		t.Fatalf("want %q, got %q", want, update.String())
+	}
+	got := update.Result.Artifacts["transform/TRANS01_ORDERS.sql"]
+	if !bytes.Contains(got, []byte(`"total"::FLOAT AS TOTAL`)) {
+		t.Fatalf("want the TOTAL column cast to FLOAT once it changed, got %s", got)
+	}
+}
+
+// countingFS counts the times each file of a [fstest.MapFS] is opened.
+type countingFS struct {
+	fstest.MapFS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.MapFS.Open(name)
+}
+
+func TestGenerate_ReusesTheInferenceOfUnchangedFilesFromTheCacheDir(t *testing.T) {
+	t.Parallel()
+	input := &countingFS{
+		MapFS: fstest.MapFS{
+			"ORDERS.csv":    {Data: []byte("id,total\n1,9\n")},
+			"CUSTOMERS.csv": {Data: []byte("id,nickname\n1,NA\n")},
+		},
+		opens: make(map[string]int),
+	}
+	opts := templater.Options{Input: input, Project: "SHOP", Profile: true, CacheDir: t.TempDir()}
+	first, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.opens = make(map[string]int)
+	input.MapFS["ORDERS.csv"] = &fstest.MapFile{Data: []byte("id,total\n1,9.5\n")}
+	second, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.opens["CUSTOMERS.csv"] != 1 {
+		t.Fatalf("want the unchanged CUSTOMERS.csv only opened to hash it, got %d opens", input.opens["CUSTOMERS.csv"])
+	}
+	if input.opens["ORDERS.csv"] < 2 {
+		t.Fatalf("want the changed ORDERS.csv inferred again, got %d opens", input.opens["ORDERS.csv"])
+	}
+	if !cmp.Equal(first.Diagnostics, second.Diagnostics) {
+		t.Fatal(cmp.Diff(first.Diagnostics, second.Diagnostics))
+	}
+	third, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(second.Artifacts, third.Artifacts) {
+		t.Fatal(cmp.Diff(second.Artifacts, third.Artifacts))
+	}
+	got := second.Artifacts["transform/TRANS01_ORDERS.sql"]
+	if !bytes.Contains(got, []byte(`"total"::FLOAT AS TOTAL`)) {
+		t.Fatalf("want the TOTAL column cast to FLOAT once it changed, got %s", got)
+	}
+}
+
+func TestGenerate_GeneratesTheSameProjectFromTheCachedSummaries(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"CUSTOMERS.csv": {Data: []byte("id,email,first_name,tier\n1,ada@example.com,Ada,gold\n2,bob@example.com,Bob,silver\n3,ada@example.com,Ada,gold\n")},
+		"ORDERS.csv":    {Data: []byte("id,customer_id,total\n1,1,9.5\n2,3,1\n3,3,2.25\n")},
+	}
+	cacheDir := t.TempDir()
+	for _, pii := range []bool{true, false} {
+		opts := templater.Options{
+			Input:         input,
+			Project:       "SHOP",
+			PII:           pii,
+			Profile:       true,
+			Relationships: true,
+			CUE:           true,
+			CUEEnums:      10,
+			Tests:         templater.TestPolicy{Mode: templater.TestsError, Confidence: 1, MaxAcceptedValues: 5},
+		}
+		uncached, err := templater.Generate(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaked := bytes.Contains(uncached.Artifacts["transform/_models_schema.yml"], []byte("ada@example.com")); leaked == pii {
+			t.Fatalf("PII %t: want emails in the accepted values %t, got %t", pii, !pii, leaked)
+		}
+		opts.CacheDir = cacheDir
+		_, err = templater.Generate(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cached, err := templater.Generate(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(uncached.Artifacts, cached.Artifacts) {
+			t.Fatalf("PII %t: %s", pii, cmp.Diff(uncached.Artifacts, cached.Artifacts))
+		}
+	}
+}
+
+func TestGenerate_WritesLandingDDLMatchingTheCSV(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("id,payload\r\n1,\"{\"\"a\"\":1}\"\r\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", UnpackPaths: []string{"payload"}, DDL: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `CREATE SCHEMA IF NOT EXISTS STAGING;
+
+CREATE OR REPLACE FILE FORMAT STAGING.ORDERS_CSV
+  TYPE = CSV
+  FIELD_DELIMITER = ','
+  RECORD_DELIMITER = '\r\n'
+  SKIP_HEADER = 1
+  FIELD_OPTIONALLY_ENCLOSED_BY = '"'
+  NULL_IF = ('', '<nil>', 'NA', 'NaN')
+  EMPTY_FIELD_AS_NULL = TRUE;
+
+CREATE STAGE IF NOT EXISTS STAGING.LANDING;
+
+CREATE TABLE IF NOT EXISTS STAGING.ORDERS (
+  "id" VARCHAR,
+  "payload" VARIANT
+);
+
+COPY INTO STAGING.ORDERS ("id", "payload")
+  FROM (SELECT $1, PARSE_JSON($2) FROM @STAGING.LANDING/ORDERS.csv)
+  FILE_FORMAT = (FORMAT_NAME = 'STAGING.ORDERS_CSV');
+`
+	got := string(result.Artifacts["ddl/ORDERS.sql"])
+	if want != got {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerate_QuotesLandingIdentifiersThatAreInvalidUnquoted(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"2022 sales/order.csv": {Data: []byte("id\n1\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", DDL: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ddl := result.Artifacts["ddl/2022 sales/2022 SALES_ORDER.sql"]
+	for _, statement := range []string{
+		`CREATE SCHEMA IF NOT EXISTS "2022 SALES";`,
+		`CREATE OR REPLACE FILE FORMAT "2022 SALES".ORDER_CSV`,
+		`CREATE STAGE IF NOT EXISTS "2022 SALES".LANDING;`,
+		`CREATE TABLE IF NOT EXISTS "2022 SALES"."ORDER" (`,
+		`COPY INTO "2022 SALES"."ORDER" ("id")`,
+		`FROM (SELECT $1 FROM @"2022 SALES".LANDING/order.csv)`,
+		`FILE_FORMAT = (FORMAT_NAME = '"2022 SALES".ORDER_CSV');`,
+	} {
+		if !bytes.Contains(ddl, []byte(statement)) {
+			t.Errorf("want %s, got %s", statement, ddl)
+		}
+	}
+	sources := result.Artifacts["_source_schema.yml"]
+	for _, quoting := range []string{"schema: 2022 SALES\n    quoting:\n      schema: true\n", "- name: ORDER\n        quoting:\n          identifier: true\n"} {
+		if !bytes.Contains(sources, []byte(quoting)) {
+			t.Errorf("want %q, got %s", quoting, sources)
+		}
+	}
+}
+
+func TestGenerateProjectModel_EnforcesContractsWithDialectDataTypes(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name: "ORDERS",
+		Fields: map[string]templater.Field{
+			"id":    {Path: `"id"`, Node: "id", InferredType: "INTEGER"},
+			"total": {Path: `"total"`, Node: "total", InferredType: "FLOAT"},
+			"order": {Path: `"order"`, Node: "order", InferredType: "STRING"},
+		},
+	}
+	models := templater.GenerateProjectModel([]*templater.Table{table}, templater.WithDialect(templater.Postgres, templater.IdentifierQuote), templater.WithContracts())
+	model := models.Models[0]
+	if model.Config == nil || model.Config.Contract == nil || !model.Config.Contract.Enforced {
+		t.Fatalf("want an enforced contract, got %+v", model.Config)
+	}
+	want := map[string]string{"ID": "bigint", "ORDER": "text", "TOTAL": "double precision"}
+	got := map[string]string{}
+	for _, column := range model.Columns {
+		if column.DataType != nil {
+			got[column.Name] = *column.DataType
+		}
+	}
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerate_TypesColumnsMaskedByTheMacroAsTheirHash(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"PEOPLE.csv": {Data: []byte("id,ssn\n1,123456789\n2,987654321\n")},
+	}
+	opts := templater.Options{Input: input, Project: "SHOP", Masking: templater.MaskMacro, Contracts: true, TargetDDL: true}
+	result, err := templater.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := result.Artifacts["transform/_models_schema.yml"]
+	for _, column := range []string{"- name: ID\n        data_type: INTEGER\n", "        data_type: VARCHAR\n        tags:\n          - pii\n"} {
+		if !bytes.Contains(properties, []byte(column)) {
+			t.Errorf("want %q, got %s", column, properties)
+		}
+	}
+	want := "CREATE TABLE IF NOT EXISTS TRANS01_PEOPLE (\n  ID INTEGER,\n  SSN VARCHAR\n);\n"
+	got := string(result.Artifacts["ddl/transform/TRANS01_PEOPLE.sql"])
+	if want != got {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerateTargetDDL_TypesColumnsAsTheModelNamesThem(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name: "ORDERS",
+		Fields: map[string]templater.Field{
+			"id":    {Path: `"id"`, Node: "id", InferredType: "INTEGER"},
+			"order": {Path: `"order"`, Node: "order", InferredType: "STRING"},
+		},
+	}
+	want := "CREATE TABLE IF NOT EXISTS TRANS01_ORDERS (\n  ID INT64,\n  `ORDER` STRING\n);\n"
+	got := templater.GenerateTargetDDL(table, templater.WithDialect(templater.BigQuery, templater.IdentifierQuote))
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerateTargetDDL_QuotesTableNamesThatAreInvalidUnquoted(t *testing.T) {
+	t.Parallel()
+	table := &templater.Table{
+		Name: "DAILY SALES",
+		Fields: map[string]templater.Field{
+			"id": {Path: `"id"`, Node: "id", InferredType: "INTEGER"},
+		},
+	}
+	want := "CREATE TABLE IF NOT EXISTS \"TRANS01_DAILY SALES\" (\n  ID INTEGER\n);\n"
+	got := templater.GenerateTargetDDL(table)
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestGenerate_WritesCUEDefinitionsValidatingRows(t *testing.T) {
+	t.Parallel()
+	input := fstest.MapFS{
+		"ORDERS.csv": {Data: []byte("TEAM,WINS,note,payload\nA,1,,\"{\"\"a\"\":1.5}\"\nB,2,x,\nA,3,,\"{\"\"a\"\":2}\"\n")},
+	}
+	result, err := templater.Generate(context.Background(), templater.Options{Input: input, Project: "SHOP", UnpackPaths: []string{"payload"}, CUE: true, CUEEnums: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `// #ORDERS is a row of ORDERS.csv.
+#ORDERS: {
+	TEAM:  "A" | "B"
+	WINS:  int
+	note?: string | null
+	// JSON, unpacked into A.
+	payload?: string | null
+}
+`
+	got := string(result.Artifacts["schema.cue"])
+	if want != got {
+		t.Fatal(cmp.Diff(want, got))
+	}
+	c := cuecontext.New()
+	schema := c.CompileString(got).LookupPath(cue.ParsePath("#ORDERS"))
+	for _, exported := range []string{
+		`{"TEAM": "A", "WINS": 1, "note": null, "payload": "{\"a\":1.5}"}`,
+		`{"TEAM": "B", "WINS": 2, "note": "x", "payload": null}`,
+	} {
+		if err := schema.Unify(c.CompileString(exported)).Validate(cue.Concrete(true)); err != nil {
+			t.Fatalf("want the exported row %s to pass, got %v", exported, err)
+		}
+	}
+	for _, invalid := range []string{`{TEAM: "C", WINS: 4}`, `{TEAM: "A", WINS: 4.5}`, `{TEAM: "A", WINS: 4, extra: 1}`, `{TEAM: "A", WINS: 4, payload: {a: 1}}`} {
+		if err := schema.Unify(c.CompileString(invalid)).Validate(cue.Concrete(true)); err == nil {
+			t.Fatalf("want %s to fail validation", invalid)
+		}
+	}
+}
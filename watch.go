@@ -0,0 +1,167 @@
+package templater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statKey identifies a file by its modification time and size, which is cheap enough to check every time a [Watcher] polls.
+func statKey(fsys fs.FS, path string) (string, error) {
+	info, err := fs.Stat(fsys, path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// A Watcher regenerates a project each time the CSV's of its Input change.
+// Only the tables whose files changed are inferred again; the rest are reused from the previous run.
+type Watcher struct {
+	opts   Options
+	cache  *tableCache
+	inputs map[string]string
+	result *Result
+}
+
+// NewWatcher returns a [Watcher] of the Input of the [Options]. Nothing is read until it is first polled.
+func NewWatcher(opts Options) *Watcher {
+	return &Watcher{opts: opts, cache: newTableCache(statKey, "", opts.detectsPII())}
+}
+
+// A WatchUpdate describes a project regenerated by a [Watcher].
+//
+// Inputs: Each CSV created, modified or deleted since the previous update. Only their Path and Status are set.
+//
+// Inferred: The CSV's whose tables were inferred again, rather than reused from the previous update.
+//
+// Result: The regenerated project.
+//
+// Changes: Each file of the project that differs from the previous update.
+type WatchUpdate struct {
+	Inputs   []FileChange
+	Inferred []string
+	Result   *Result
+	Changes  []FileChange
+}
+
+// String summarises the [WatchUpdate], with a line for each input that changed followed by a count of the changes to the project.
+func (u WatchUpdate) String() string {
+	b := new(strings.Builder)
+	for _, input := range u.Inputs {
+		fmt.Fprintf(b, "%s %s\n", input.Status, input.Path)
+	}
+	counts := make(map[FileStatus]int)
+	for _, change := range u.Changes {
+		counts[change.Status]++
+	}
+	fmt.Fprintf(b, "inferred %d of %d tables, %d diagnostics: %d new, %d modified, %d deleted files",
+		len(u.Inferred), len(u.Result.Tables), len(u.Result.Diagnostics), counts[FileNew], counts[FileModified], counts[FileDeleted])
+	return b.String()
+}
+
+// Poll regenerates the project if any of the CSV's of the Input changed since the previous poll, returning a [WatchUpdate].
+// The first poll always generates the project. If nothing changed, Poll returns nil.
+// A failed poll leaves the previous project in place, and the project is only generated again once the CSV's next change.
+func (w *Watcher) Poll(ctx context.Context) (*WatchUpdate, error) {
+	inputs, err := snapshotInputs(w.opts.Input)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffInputs(w.inputs, inputs)
+	if w.inputs != nil && len(changes) == 0 {
+		return nil, nil
+	}
+	w.inputs = inputs
+	w.cache.takeInferred()
+	result, err := generate(ctx, w.opts, w.cache)
+	if err != nil {
+		return nil, err
+	}
+	previous := Artifacts{}
+	if w.result != nil {
+		previous = w.result.Artifacts
+	}
+	w.result = result
+	return &WatchUpdate{
+		Inputs:   changes,
+		Inferred: w.cache.takeInferred(),
+		Result:   result,
+		Changes:  diffArtifacts(previous, result.Artifacts),
+	}, nil
+}
+
+// snapshotInputs returns the [statKey] of each CSV of the [fs.FS], by path.
+func snapshotInputs(fsys fs.FS) (map[string]string, error) {
+	inputs := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(path) != ".csv" || d.IsDir() {
+			return nil
+		}
+		key, err := statKey(fsys, path)
+		if err != nil {
+			return err
+		}
+		inputs[path] = key
+		return nil
+	})
+	return inputs, err
+}
+
+// diffInputs returns a [FileChange] for each input created, modified or deleted between two snapshots, sorted by path.
+func diffInputs(before, after map[string]string) []FileChange {
+	changes := []FileChange{}
+	for path, key := range after {
+		previous, ok := before[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: path, Status: FileNew})
+		case previous != key:
+			changes = append(changes, FileChange{Path: path, Status: FileModified})
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Status: FileDeleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// watchProject polls the [Watcher] at the interval until the context is done.
+// Each [WatchUpdate] is passed to write, and then summarised to the io.Writer.
+// Errors are printed rather than returned, leaving the previous output in place so that watching carries on.
+func watchProject(ctx context.Context, watcher *Watcher, interval time.Duration, write func(*Result) error, w io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		update, err := watcher.Poll(ctx)
+		if err == nil && update != nil {
+			err = write(update.Result)
+		}
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			fmt.Fprintln(w, err.Error())
+		case update != nil:
+			fmt.Fprintln(w, update)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package templater
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// A TableError is an error inferring a single table, naming the file the table was read from.
+type TableError struct {
+	File string
+	Err  error
+}
+
+func (e *TableError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *TableError) Unwrap() error {
+	return e.Err
+}
+
+// TableErrors collects the errors of every table that failed inference, in the order of the tables.
+// Each error is a [TableError] naming the file of its table.
+type TableErrors []error
+
+func (e TableErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// workerCount returns the number of workers to run, defaulting to one per CPU.
+func workerCount(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// forEachTable calls fn with each [Table], running at most the given number of calls at once.
+// Every table is processed, even once one has failed, and the errors are returned as [TableErrors] in the order of the tables,
+// so the result doesn't depend on the order the workers finish in.
+// Once the context is done, no more tables are started and its error is returned.
+func forEachTable(ctx context.Context, tables []*Table, workers int, fn func(*Table) error) error {
+	errs := make([]error, len(tables))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount(workers) && w < len(tables); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = fn(tables[i])
+			}
+		}()
+	}
+	for i := range tables {
+		if ctx.Err() != nil {
+			break
+		}
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	failed := TableErrors{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -2,11 +2,12 @@ package templater
 
 import (
 	"fmt"
-	"os"
 	"sort"
+	"strconv"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/encoding/yaml"
+	"golang.org/x/exp/maps"
 )
 
 // Test: DBT Reference: https://docs.getdbt.com/reference/resource-properties/tests.
@@ -15,10 +16,23 @@ type Test struct {
 }
 
 // Column: DBT Reference: https://docs.getdbt.com/reference/resource-properties/columns.
+//
+// Tests holds either the bare names of generic tests, or single entry maps of a test name to its arguments.
+//
+// Quote is set for columns whose names are only valid as quoted identifiers.
+//
+// DataType is set for the columns of models with an enforced [Contract].
+//
+// Quoting is set for the tables of a [Source] whose names are only valid as quoted identifiers.
 type Column struct {
-	Name        string   `yaml:"name"`
-	Description *string  `yaml:"description, omitempty"`
-	Tests       []string `yaml:"tests, omitempty"`
+	Name        string            `yaml:"name"`
+	Quote       *bool             `yaml:"quote, omitempty"`
+	Quoting     map[string]bool   `yaml:"quoting, omitempty"`
+	Description *string           `yaml:"description, omitempty"`
+	Meta        map[string]string `yaml:"meta, omitempty"`
+	DataType    *string           `yaml:"data_type, omitempty"`
+	Tags        []string          `yaml:"tags, omitempty"`
+	Tests       []any             `yaml:"tests, omitempty"`
 }
 
 // Sources: DBT Reference: https://docs.getdbt.com/reference/dbt-jinja-functions/source.
@@ -28,10 +42,15 @@ type Sources struct {
 }
 
 // Sources: DBT Reference: https://docs.getdbt.com/reference/dbt-jinja-functions/source.
+//
+// Quoting is set for sources whose schema is only valid as a quoted identifier.
+//
+// Reference: https://docs.getdbt.com/reference/resource-properties/quoting.
 type Source struct {
-	Name   string   `yaml:"name"`
-	Schema string   `yaml:"schema"`
-	Tables []Column `yaml:"tables, omitempty"`
+	Name    string          `yaml:"name"`
+	Schema  string          `yaml:"schema"`
+	Quoting map[string]bool `yaml:"quoting, omitempty"`
+	Tables  []Column        `yaml:"tables, omitempty"`
 }
 
 // Models: DBT Reference: https://docs.getdbt.com/docs/dbt-cloud-apis/metadata-schema-model.
@@ -42,27 +61,169 @@ type Models struct {
 
 // Models: DBT Reference: https://docs.getdbt.com/docs/dbt-cloud-apis/metadata-schema-model.
 type Model struct {
-	Name        string   `yaml:"name"`
-	Description *string  `yaml:"description, omitempty"`
-	Tests       []Test   `yaml:"tests, omitempty"`
-	Columns     []Column `yaml:"columns"`
+	Name        string            `yaml:"name"`
+	Description *string           `yaml:"description, omitempty"`
+	Config      *Config           `yaml:"config, omitempty"`
+	Meta        map[string]string `yaml:"meta, omitempty"`
+	Tests       []Test            `yaml:"tests, omitempty"`
+	Columns     []Column          `yaml:"columns"`
+}
+
+// Config: DBT Reference: https://docs.getdbt.com/reference/model-configs.
+type Config struct {
+	Contract *Contract `yaml:"contract, omitempty"`
+}
+
+// Contract: DBT Reference: https://docs.getdbt.com/reference/resource-configs/contract.
+type Contract struct {
+	Enforced bool `yaml:"enforced"`
+}
+
+// A ModelOption configures the optional contents of the generated models,
+// both the [Models] generated by [GenerateProjectModel] and the SQL generated by [GenerateColumnsSQL].
+type ModelOption func(*modelConfig)
+
+// modelConfig holds the configuration applied by each [ModelOption].
+type modelConfig struct {
+	tests         TestPolicy
+	relationships []Relationship
+	masking       MaskingMode
+	dialect       Dialect
+	identifiers   IdentifierStrategy
+	naming        NamingConvention
+	order         ColumnOrder
+	contracts     bool
+}
+
+// newModelConfig applies each [ModelOption] to the default configuration.
+// By default, identifiers are quoted where Snowflake requires it.
+func newModelConfig(opts ...ModelOption) modelConfig {
+	config := modelConfig{
+		dialect:     Snowflake,
+		identifiers: IdentifierQuote,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// columnName returns the name of a target column, following the configured [NamingConvention],
+// that is valid in the configured [Dialect]. It reports whether the name must be quoted.
+// Names that keep the case of the source are quoted wherever the Dialect would otherwise change their case.
+// Inferred nodes, including those renamed to resolve a collision, have already been named, so naming leaves them as they are.
+func (c modelConfig) columnName(node string) (string, bool) {
+	name, quoted := c.dialect.SafeIdentifier(c.naming.Name(node), c.identifiers)
+	if c.naming.Case == CaseKeep && !c.dialect.PreservesCase(name) {
+		quoted = true
+	}
+	return name, quoted
+}
+
+// columnSQL returns the name of a target column as it should appear in SQL, quoted if necessary.
+func (c modelConfig) columnSQL(node string) string {
+	name, quoted := c.columnName(node)
+	if quoted {
+		return c.dialect.Quote(name)
+	}
+	return name
+}
+
+// dataType returns the data type of the target column of a [Field] in the configured [Dialect].
+// PII columns wrapped in the masking macro hold its hash of their value, so are VARCHAR whatever they were inferred as.
+func (c modelConfig) dataType(field Field) string {
+	if c.masking == MaskMacro && field.PII != "" {
+		return c.dialect.DataType("VARCHAR")
+	}
+	return c.dialect.DataType(field.InferredType)
+}
+
+// WithColumnTests suggests tests for each column, as supported by the values observed during inference.
+func WithColumnTests(policy TestPolicy) ModelOption {
+	return func(c *modelConfig) {
+		c.tests = policy
+	}
+}
+
+// WithRelationships adds relationships tests to the foreign key columns of the given [Relationship]s.
+// The tests are emitted according to the [TestPolicy] given by [WithColumnTests].
+func WithRelationships(relationships []Relationship) ModelOption {
+	return func(c *modelConfig) {
+		c.relationships = relationships
+	}
+}
+
+// WithMasking masks the columns classified as PII by [DetectPII], according to the [MaskingMode].
+func WithMasking(mode MaskingMode) ModelOption {
+	return func(c *modelConfig) {
+		c.masking = mode
+	}
+}
+
+// WithDialect makes the target column names valid in the [Dialect],
+// following the [IdentifierStrategy] for any that are reserved words or start with a digit.
+func WithDialect(dialect Dialect, strategy IdentifierStrategy) ModelOption {
+	return func(c *modelConfig) {
+		c.dialect = dialect
+		c.identifiers = strategy
+	}
+}
+
+// WithNaming names the target columns following the [NamingConvention].
+func WithNaming(naming NamingConvention) ModelOption {
+	return func(c *modelConfig) {
+		c.naming = naming
+	}
+}
+
+// WithColumnOrder sorts the columns of each model in the [ColumnOrder].
+func WithColumnOrder(order ColumnOrder) ModelOption {
+	return func(c *modelConfig) {
+		c.order = order
+	}
+}
+
+// WithContracts enforces a [Contract] on each model, giving each of its columns the data type of its [Field] in the [Dialect] given by [WithDialect].
+func WithContracts() ModelOption {
+	return func(c *modelConfig) {
+		c.contracts = true
+	}
 }
 
 // GenerateProject: Generate the [Models] required in _models_schema.yaml files that help define a (potentially multi-table) DBT project.
-func GenerateProjectModel(tables []*Table) Models {
+func GenerateProjectModel(tables []*Table, opts ...ModelOption) Models {
+	config := newModelConfig(opts...)
 	var models []Model
 	for _, table := range tables {
 		m := Model{}
 		m.Name = table.Name
-		for _, field := range table.Fields {
-			node := NormaliseKey(field.Node)
+		m.Meta = samplingMeta(table)
+		if config.contracts {
+			m.Config = &Config{Contract: &Contract{Enforced: true}}
+		}
+		fields := maps.Values(table.Fields)
+		config.order.sortFields(fields)
+		for _, field := range fields {
+			node := config.naming.Name(field.Node)
+			relationships := relationshipsFrom(config.relationships, Key{Table: table.Name, Column: node})
+			tests := append(config.tests.suggestTests(field, table.Rows), config.tests.relationshipTests(relationships, table.Rows, config.columnSQL)...)
+			name, quoted := config.columnName(node)
 			col := Column{
-				Name: node,
+				Name:  name,
+				Tests: tests,
+			}
+			if quoted {
+				col.Quote = &quoted
+			}
+			if config.contracts {
+				dataType := config.dataType(field)
+				col.DataType = &dataType
+			}
+			if field.PII != "" {
+				col.Meta = map[string]string{"sensitivity": "pii", "pii_category": string(field.PII)}
+				col.Tags = []string{"pii"}
 			}
 			m.Columns = append(m.Columns, col)
-			sort.Slice(m.Columns, func(i, j int) bool {
-				return m.Columns[i].Name < m.Columns[j].Name
-			})
 		}
 		models = append(models, m)
 	}
@@ -91,6 +252,45 @@ func (m Models) addDescriptions() Models {
 	return m
 }
 
+// withoutTests: Remove the column tests from the [Models].
+// Tests belong to the transform layer, there is no need to repeat them on its clones.
+func (m Models) withoutTests() Models {
+	models := make([]Model, len(m.Models))
+	copy(models, m.Models)
+	for model := range models {
+		columns := make([]Column, len(models[model].Columns))
+		copy(columns, models[model].Columns)
+		for column := range columns {
+			columns[column].Tests = nil
+		}
+		models[model].Columns = columns
+	}
+	return Models{
+		Version: 2,
+		Models:  models,
+	}
+}
+
+// withoutContracts: Remove the contracts, and the data types of their columns, from the [Models].
+// Contracts are enforced on the transform layer, its clones only select from it.
+func (m Models) withoutContracts() Models {
+	models := make([]Model, len(m.Models))
+	copy(models, m.Models)
+	for model := range models {
+		columns := make([]Column, len(models[model].Columns))
+		copy(columns, models[model].Columns)
+		for column := range columns {
+			columns[column].DataType = nil
+		}
+		models[model].Columns = columns
+		models[model].Config = nil
+	}
+	return Models{
+		Version: 2,
+		Models:  models,
+	}
+}
+
 // addPrefix: Add a prefix to the [Models] to help satisfy the name uniqueness constraints.
 func (m Models) addPrefix(prefix string) Models {
 	models := make([]Model, len(m.Models))
@@ -104,63 +304,111 @@ func (m Models) addPrefix(prefix string) Models {
 	}
 }
 
+// samplingMeta records the [Sampling] used to infer the fields of a [Table], so reviewers know how much of it was seen.
+// It returns nil if every row was read.
+func samplingMeta(table *Table) map[string]string {
+	if !table.Sampling.enabled() {
+		return nil
+	}
+	meta := map[string]string{
+		"sampling":     table.Sampling.String(),
+		"sampled_rows": strconv.Itoa(table.Rows),
+	}
+	if table.TotalRows > 0 {
+		meta["total_rows"] = strconv.Itoa(table.TotalRows)
+	}
+	return meta
+}
+
 // generateProjectSources: Generate the [Sources] required in _source_schema.yaml files that help define a (potentially multi-table) DBT project.
 // _source_schema.yaml files define DBT relations to the source tables to be transformed.
-func generateProjectSources(tables []*Table, projectName string) Sources {
-	var source Source
-
-	source.Name = projectName
-	source.Schema = "STAGING"
-	for _, column := range tables {
-		columnDescription := fmt.Sprintf("TODO: Description for TABLE, %s", column.Name)
+// Tables at the top of the project belong to a source named after the project, in the STAGING schema.
+// Tables in subdirectories belong to a source named after their directory, in a schema of the same name.
+// Tables renamed to resolve a collision read from the same source table as the table they collided with, which is listed once.
+// Schemas and tables whose names aren't valid unquoted in the [Dialect] given by [WithDialect] are quoted, as they are in the landing DDL.
+func generateProjectSources(tables []*Table, projectName string, opts ...ModelOption) Sources {
+	config := newModelConfig(opts...)
+	sources := make(map[string]*Source)
+	listed := make(map[[2]string]bool)
+	for _, table := range tables {
+		sourceName, tableName := table.source()
+		source, ok := sources[sourceName]
+		if !ok {
+			source = &Source{Name: sourceName, Schema: table.sourceSchema()}
+			if config.dialect.NeedsQuoting(source.Schema) {
+				source.Quoting = map[string]bool{"schema": true}
+			}
+			sources[sourceName] = source
+		}
+		if listed[[2]string{sourceName, tableName}] {
+			continue
+		}
+		listed[[2]string{sourceName, tableName}] = true
+		columnDescription := fmt.Sprintf("TODO: Description for TABLE, %s", tableName)
 		t := Column{
-			Name:        column.Name,
+			Name:        tableName,
 			Description: &columnDescription,
 		}
+		if config.dialect.NeedsQuoting(tableName) {
+			t.Quoting = map[string]bool{"identifier": true}
+		}
 		source.Tables = append(source.Tables, t)
 		sort.Slice(source.Tables, func(i, j int) bool {
 			return source.Tables[i].Name < source.Tables[j].Name
 		})
 	}
-	return Sources{
-		Version: 2,
-		Sources: []Source{source},
+	if len(sources) == 0 {
+		sources[projectName] = &Source{Name: projectName, Schema: "STAGING"}
 	}
+	names := maps.Keys(sources)
+	sort.Strings(names)
+	project := Sources{Version: 2}
+	for _, name := range names {
+		project.Sources = append(project.Sources, *sources[name])
+	}
+	return project
 }
 
-// writeProjectModels: Write the [Models] to transform/_models_schema.yml and public/_models_schema respectively.
-func writeProject(c *cue.Context, models Models, sources Sources, tables []*Table) error {
+// writeProjectModels: Write the [Models] to the [Sink] as transform/_models_schema.yml and public/_models_schema respectively.
+func writeProject(sink Sink, c *cue.Context, opts Options, models Models, sources Sources, tables []*Table, modelOpts ...ModelOption) error {
 	for _, table := range tables {
-		err := writeTableModel(table)
+		err := writeTableModel(sink, table, modelOpts...)
 		if err != nil {
 			return err
 		}
 	}
-	err := writePropertyToFile("transform/_models_schema.yml", c, models.addPrefix("TRANS01"))
+	transform, err := encodeProperty(c, models.addPrefix("TRANS01"))
 	if err != nil {
 		return err
 	}
-	err = writePropertyToFile("public/_models_schema.yml", c, models.addDescriptions())
+	if opts.Tests.Mode == TestsCommented {
+		transform = commentOutColumnTests(transform)
+	}
+	err = sink.WriteFile("transform/_models_schema.yml", transform)
 	if err != nil {
 		return err
 	}
-	err = writePropertyToFile("_source_schema.yml", c, sources)
+	err = writePropertyToFile(sink, "public/_models_schema.yml", c, models.withoutTests().withoutContracts().addDescriptions())
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-// writePropertyToFile: takes either a [Source] or a [Model] and writes it to file
-func writePropertyToFile[T Sources | Models](path string, c *cue.Context, t T) error {
-	encoded, err := yaml.Encode(c.Encode(t))
+	err = writePropertyToFile(sink, "_source_schema.yml", c, sources)
 	if err != nil {
 		return err
 	}
-	path = fmt.Sprintf("output/%s", path)
-	err = os.WriteFile(path, encoded, 0644)
+	return nil
+}
+
+// writePropertyToFile: takes either a [Source] or a [Model] and writes it to the [Sink]
+func writePropertyToFile[T Sources | Models](sink Sink, path string, c *cue.Context, t T) error {
+	encoded, err := encodeProperty(c, t)
 	if err != nil {
 		return err
 	}
-	return nil
+	return sink.WriteFile(path, encoded)
+}
+
+// encodeProperty: takes either a [Source] or a [Model] and encodes it as YAML.
+func encodeProperty[T Sources | Models](c *cue.Context, t T) ([]byte, error) {
+	return yaml.Encode(c.Encode(t))
 }